@@ -0,0 +1,57 @@
+package ipsearch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NewIPASN creates a new IP range from an ASN CSV line, it returns nil for a
+// malformed line, see ParseASNLine.
+func NewIPASN(line string) *IPRange {
+	ipRange, err := ParseASNLine(line)
+	if err != nil {
+		log.Debugf("Skip the ASN line: %v", err)
+		return nil
+	}
+	return ipRange
+}
+
+// ParseASNLine parses an ASN CSV line of the ip-location-db asn-ipv4.csv and
+// asn-ipv6.csv files, in the "start,end,asn,organization" format:
+//
+//	1.0.0.0,1.0.0.255,13335,Cloudflare
+//	1.0.4.0,1.0.7.255,38803,"Wirelink, Inc."
+//
+// The organization may be quoted, the unquoted commas are kept in it.
+func ParseASNLine(line string) (*IPRange, error) {
+	fields, ok := splitQuoted(line)
+	if !ok || len(fields) < 3 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidASN, line)
+	}
+	start, err := ParseIP(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidASN, err)
+	}
+	end, err := ParseIP(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidASN, err)
+	}
+	if start.IsIPv4() != end.IsIPv4() || end.Less(start) {
+		return nil, fmt.Errorf("%w: bad range %q", ErrInvalidASN, line)
+	}
+	asn, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "AS"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad ASN %q", ErrInvalidASN, line)
+	}
+	return &IPRange{
+		rangeType: ASN,
+		bucket:    bucketOf(start),
+		start:     start,
+		end:       end,
+		asn:       uint32(asn),
+		org:       strings.Join(fields[3:], ","),
+	}, nil
+}
@@ -0,0 +1,56 @@
+package ipsearch_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+var asns = []string{
+	"1.0.0.0,1.0.0.255,13335,Cloudflare",
+	"1.0.4.0,1.0.7.255,38803,\"Wirelink, Inc.\"",
+	"1.0.16.0,1.0.31.255,2519,ARTERIA Networks Corporation",
+	"8.8.8.0,8.8.8.255,15169,Google LLC",
+	"114.114.112.0,114.114.127.255,21859,Zenlayer Inc, US",
+	"2001:4860::,2001:4860:ffff:ffff:ffff:ffff:ffff:ffff,15169,Google LLC",
+}
+
+func TestASN(t *testing.T) {
+	search, err := ipsearch.NewIPSearchStrict(asns, ipsearch.ASN)
+	assert.Nil(t, err)
+	assert.Equal(t, len(asns), search.Len())
+
+	ip := search.Search("8.8.8.8")
+	assert.NotNil(t, ip)
+	assert.Equal(t, ipsearch.ASN, ip.Type())
+	assert.Equal(t, uint32(15169), ip.ASN())
+	assert.Equal(t, "Google LLC", ip.Organization())
+	assert.Equal(t, "", ip.Country())
+	assert.Equal(t, "8.8.8.0,8.8.8.255,15169,Google LLC", ip.String())
+
+	assert.Equal(t, "Wirelink, Inc.", search.Search("1.0.5.1").Organization())
+	assert.Equal(t, "Zenlayer Inc, US", search.Search("114.114.114.114").Organization())
+	assert.Equal(t, uint32(15169), search.Search("2001:4860:4860::8888").ASN())
+	assert.Nil(t, search.Search("1.0.1.1"))
+
+	for _, line := range []string{
+		"1.0.0.0,1.0.0.255",
+		"1.0.0.0,1.0.0.255,AS",
+		"1.0.0.0,1.0.0.255,4294967296,Overflow",
+		"1.0.0.255,1.0.0.0,13335,Cloudflare",
+		"1.0.0.0,2001:db8::,13335,Cloudflare",
+		"garbage,1.0.0.255,13335,Cloudflare",
+		"1.0.0.0,1.0.0.255,13335,\"Cloudflare",
+	} {
+		_, err := ipsearch.ParseIPRange(line, ipsearch.ASN)
+		assert.True(t, errors.Is(err, ipsearch.ErrInvalidASN), line)
+		assert.Nil(t, ipsearch.NewIPRange(line, ipsearch.ASN), line)
+	}
+	ip, err = ipsearch.ParseASNLine("1.0.0.0,1.0.0.255,AS13335,")
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(13335), ip.ASN())
+	assert.Equal(t, "", ip.Organization())
+}
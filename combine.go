@@ -0,0 +1,172 @@
+package ipsearch
+
+import (
+	"sort"
+)
+
+// CombineIPRanges combines the IP ranges of the different datasets, like a
+// country dataset and an ASN dataset, into one list answering all of them.
+//
+// The ranges are cut at the boundaries of all of the lists, every piece is a
+// copy of the range of the first list covering it, with the empty country,
+// ASN and organization taken from the ranges of the later lists, and the
+// metadata of all of them, the earlier lists win the conflicting keys. The
+// adjacent pieces covered by the same ranges are merged, and the cut CIDR
+// ranges become the Geo ranges. The ranges of a list should not overlap
+// each other, see ResolveOverlaps.
+func CombineIPRanges(lists ...[]*IPRange) []*IPRange {
+	var combined []*IPRange
+	metas := make(metadataInterner)
+	sweepIPRanges(lists, func(start, end Uint128, covering []*IPRange) {
+		combined = append(combined, combineRanges(covering, start, end, metas))
+	})
+
+	// the pieces of the CIDR ranges keep the CIDR if they are not cut
+	for _, ip := range combined {
+		if ip.cidr != "" && (ip.rangeType == CIDR || ip.rangeType == MMDB) {
+			if src, err := ParseCIDR(ip.cidr); err != nil || src.start != ip.start || src.end != ip.end {
+				ip.rangeType = Geo
+				ip.cidr = ""
+			}
+		}
+	}
+	return combined
+}
+
+// sweepIPRanges cuts the lists at the boundaries of all of the ranges, and
+// calls fn for every piece [start, end] covered by any range, in the order of
+// the addresses. covering[i] is the range of the i-th list covering the
+// piece, or nil, it is reused by the calls. The adjacent pieces covered by
+// the same ranges are merged.
+func sweepIPRanges(lists [][]*IPRange, fn func(start, end Uint128, covering []*IPRange)) {
+	sorted := make([][]*IPRange, len(lists))
+	var bounds []Uint128
+	for i, list := range lists {
+		for _, ip := range list {
+			if ip == nil {
+				continue
+			}
+			sorted[i] = append(sorted[i], ip)
+			bounds = append(bounds, ip.start)
+			if next, ok := ip.end.addOne(); ok {
+				bounds = append(bounds, next)
+			}
+		}
+		sort.SliceStable(sorted[i], func(a, b int) bool {
+			return sorted[i][a].start.Less(sorted[i][b].start)
+		})
+	}
+	sort.Slice(bounds, func(a, b int) bool { return bounds[a].Less(bounds[b]) })
+
+	next := make([]int, len(sorted))
+	covering := make([]*IPRange, len(sorted))
+	pending := make([]*IPRange, len(sorted))
+	var pendingStart, pendingEnd Uint128
+	hasPending := false
+	flush := func() {
+		if hasPending {
+			fn(pendingStart, pendingEnd, pending)
+			hasPending = false
+		}
+	}
+
+	for i, start := range bounds {
+		if i > 0 && bounds[i-1] == start {
+			continue
+		}
+		// the piece is [start, the next bound - 1]
+		end := Uint128{^uint64(0), ^uint64(0)}
+		for j := i + 1; j < len(bounds); j++ {
+			if bounds[j] != start {
+				end, _ = bounds[j].subOne()
+				break
+			}
+		}
+
+		covered := false
+		for l, list := range sorted {
+			for next[l] < len(list) && list[next[l]].end.Less(start) {
+				next[l]++
+			}
+			covering[l] = nil
+			if next[l] < len(list) && !start.Less(list[next[l]].start) {
+				covering[l] = list[next[l]]
+				covered = true
+			}
+		}
+		if !covered {
+			flush()
+			continue
+		}
+
+		if hasPending && sameRanges(covering, pending) {
+			if next, ok := pendingEnd.addOne(); ok && next == start {
+				pendingEnd = end
+				continue
+			}
+		}
+		flush()
+		copy(pending, covering)
+		pendingStart, pendingEnd = start, end
+		hasPending = true
+	}
+	flush()
+}
+
+// CombineIPSearch combines the ranges of the IPSearches into a new IPSearch,
+// see CombineIPRanges, the IPSearch cannot be reloaded. The nested ranges of
+// an IPSearch are resolved to the most specific ones first.
+func CombineIPSearch(searches ...*IPSearch) *IPSearch {
+	lists := make([][]*IPRange, len(searches))
+	for i, s := range searches {
+		lists[i] = disjointRanges(s.Container())
+	}
+	return newIPSearch(newContainer(CombineIPRanges(lists...), MapListBackend), nil)
+}
+
+// sameRanges returns true if the two lists have the same ranges.
+func sameRanges(a, b []*IPRange) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// combineRanges creates a piece of [start, end] with the fields of the
+// covering ranges, the earlier ones win.
+func combineRanges(covering []*IPRange, start, end Uint128, metas metadataInterner) *IPRange {
+	var piece *IPRange
+	var meta metadata
+	for i := len(covering) - 1; i >= 0; i-- {
+		ip := covering[i]
+		if ip == nil {
+			continue
+		}
+		if piece == nil {
+			copied := *ip
+			piece = &copied
+		} else {
+			country, asn, org := piece.country, piece.asn, piece.org
+			*piece = *ip
+			if piece.country == "" {
+				piece.country = country
+			}
+			if piece.asn == 0 {
+				piece.asn = asn
+			}
+			if piece.org == "" {
+				piece.org = org
+			}
+		}
+		if len(ip.meta) > 0 {
+			meta = meta.with(ip.meta)
+		}
+	}
+	piece.bucket = bucketOf(start)
+	piece.start = start
+	piece.end = end
+	piece.meta = metas.intern(meta)
+	return piece
+}
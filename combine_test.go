@@ -0,0 +1,103 @@
+package ipsearch_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+func TestCombineIPRanges(t *testing.T) {
+	countries := ipsearch.NewIPRangeSlice([]string{
+		"1.0.0.0,1.0.0.255,AU",
+		"1.0.1.0,1.0.3.255,CN",
+		"1.0.4.0,1.0.7.255,AU",
+		"8.8.8.0,8.8.8.255,US",
+	}, ipsearch.Geo)
+	asns := ipsearch.NewIPRangeSlice(asns, ipsearch.ASN)
+	tagged := []*ipsearch.IPRange{
+		ipsearch.NewIPRange("1.0.4.0/23", ipsearch.CIDR).WithMetadata(map[string]string{"dc": "syd1"}),
+		ipsearch.NewIPRange("8.8.8.0/24", ipsearch.CIDR).WithMetadata(map[string]string{"dc": "sjc1"}),
+	}
+
+	combined := ipsearch.CombineIPRanges(countries, asns, tagged)
+	var ranges []string
+	for _, ip := range combined {
+		ranges = append(ranges, ip.Range()+" "+ip.Country()+" "+ip.Organization()+" "+ip.Meta("dc"))
+	}
+	assert.Equal(t, []string{
+		"1.0.0.0 - 1.0.0.255 AU Cloudflare ",
+		"1.0.1.0 - 1.0.3.255 CN  ",
+		"1.0.4.0 - 1.0.5.255 AU Wirelink, Inc. syd1",
+		"1.0.6.0 - 1.0.7.255 AU Wirelink, Inc. ",
+		"1.0.16.0 - 1.0.31.255  ARTERIA Networks Corporation ",
+		"8.8.8.0 - 8.8.8.255 US Google LLC sjc1",
+		"114.114.112.0 - 114.114.127.255  Zenlayer Inc, US ",
+		"2001:4860:: - 2001:4860:ffff:ffff:ffff:ffff:ffff:ffff  Google LLC ",
+	}, ranges)
+
+	// the first list wins the type
+	assert.Equal(t, ipsearch.Geo, combined[0].Type())
+	assert.Equal(t, ipsearch.ASN, combined[4].Type())
+
+	// the uncut CIDR ranges keep the CIDR
+	combined = ipsearch.CombineIPRanges(tagged, countries)
+	assert.Equal(t, ipsearch.CIDR, combined[2].Type())
+	assert.Equal(t, "1.0.4.0/23", combined[2].CIDR())
+	assert.Equal(t, "AU", combined[2].Country())
+
+	assert.Empty(t, ipsearch.CombineIPRanges())
+	assert.Equal(t, 4, len(ipsearch.CombineIPRanges(countries)))
+}
+
+func TestCombineIPSearch(t *testing.T) {
+	geo, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	assert.Nil(t, err)
+	asn, err := ipsearch.NewIPSearchStrict(asns, ipsearch.ASN)
+	assert.Nil(t, err)
+
+	search := ipsearch.CombineIPSearch(geo, asn)
+	ip := search.Search("8.8.8.8")
+	assert.NotNil(t, ip)
+	assert.Equal(t, "US", ip.Country())
+	assert.Equal(t, uint32(15169), ip.ASN())
+	assert.Equal(t, "Google LLC", ip.Organization())
+	ip = search.Search("114.114.114.114")
+	assert.Equal(t, "CN", ip.Country())
+	assert.Equal(t, uint32(21859), ip.ASN())
+	assert.Equal(t, "2001:4860::", search.Search("2001:4860::1").Range()[:11])
+	testGeoSearch(t, search)
+
+	// the combined fields survive the snapshot
+	loaded, err := ipsearch.LoadSnapshot(bytes.NewReader(snapshot(t, search)))
+	assert.Nil(t, err)
+	assert.Equal(t, search.Search("8.8.8.8"), loaded.Search("8.8.8.8"))
+}
+
+func TestCombineIPSearchNested(t *testing.T) {
+	nested, _, err := ipsearch.NewIPSearchWithOptions(nestedCIDRs, ipsearch.CIDR, &ipsearch.LoadOptions{Backend: ipsearch.TrieBackend})
+	assert.Nil(t, err)
+	asn, err := ipsearch.NewIPSearchStrict(asns, ipsearch.ASN)
+	assert.Nil(t, err)
+
+	// the cut CIDR ranges become the Geo ranges of the pieces
+	search := ipsearch.CombineIPSearch(nested, asn)
+	assert.Equal(t, "10.1.2.0/24", search.Search("10.1.2.3").CIDR())
+	for ip, r := range map[string]string{
+		"10.1.1.1":      "10.1.0.0 - 10.1.1.255",
+		"10.1.3.1":      "10.1.3.0 - 10.1.255.255",
+		"10.0.0.1":      "10.0.0.0 - 10.0.255.255",
+		"10.2.0.1":      "10.2.0.0 - 10.255.255.255",
+		"2001:db8:1::1": "2001:db8:1:: - 2001:db8:1:ffff:ffff:ffff:ffff:ffff",
+		"2001:db8:2::1": "2001:db8:2:: - 2001:db8:ffff:ffff:ffff:ffff:ffff:ffff",
+	} {
+		found := search.Search(ip)
+		if assert.NotNil(t, found, ip) {
+			assert.Equal(t, r, found.Range(), ip)
+		}
+	}
+	assert.Nil(t, search.Search("11.0.0.1"))
+	assert.Equal(t, uint32(15169), search.Search("8.8.8.8").ASN())
+}
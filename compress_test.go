@@ -0,0 +1,77 @@
+package ipsearch_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+
+	"github.com/haoel/ipsearch"
+)
+
+func compressFile(t *testing.T, src, dst string, newWriter func(io.Writer) (io.WriteCloser, error)) {
+	data, err := os.ReadFile(src)
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	w, err := newWriter(&buf)
+	assert.Nil(t, err)
+	_, err = w.Write(data)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	assert.Nil(t, os.WriteFile(dst, buf.Bytes(), 0o644))
+}
+
+func TestCompressedFile(t *testing.T) {
+	dir := t.TempDir()
+	writers := map[string]func(io.Writer) (io.WriteCloser, error){
+		".gz":  func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		".zst": func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+		".xz":  func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+	}
+
+	for ext, newWriter := range writers {
+		cidrFile := filepath.Join(dir, "china_ip_list.txt"+ext)
+		geoFile := filepath.Join(dir, "asn-country-ipv4.csv"+ext)
+		compressFile(t, IPv4CIDRFile, cidrFile, newWriter)
+		compressFile(t, IPv4GeoFile, geoFile, newWriter)
+
+		lines, err := ipsearch.ReadFile(cidrFile)
+		assert.Nil(t, err, ext)
+		assert.Equal(t, FileLines, len(lines), ext)
+
+		search, err := ipsearch.NewIPSearchWithFile(cidrFile, ipsearch.CIDR)
+		assert.Nil(t, err, ext)
+		testCIDRSearch(t, search)
+
+		search, err = ipsearch.NewIPSearchWithFile(geoFile, ipsearch.Geo)
+		assert.Nil(t, err, ext)
+		testGeoSearch(t, search)
+
+		search, err = ipsearch.NewIPSearchWithFileStrict(geoFile, ipsearch.Geo)
+		assert.Nil(t, err, ext)
+		testGeoSearch(t, search)
+	}
+
+	ts := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer ts.Close()
+	for ext := range writers {
+		search, err := ipsearch.NewIPSearchWithFileFromURL(ts.URL+"/asn-country-ipv4.csv"+ext, ipsearch.Geo)
+		assert.Nil(t, err, ext)
+		testGeoSearch(t, search)
+	}
+
+	// corrupted gzip data
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "bad.gz"), []byte{0x1f, 0x8b, 0, 0}, 0o644))
+	_, err := ipsearch.ReadFile(filepath.Join(dir, "bad.gz"))
+	assert.NotNil(t, err)
+	_, err = ipsearch.NewIPSearchWithFile(filepath.Join(dir, "bad.gz"), ipsearch.CIDR)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,45 @@
+package ipsearch
+
+// Container is the index structure of IP ranges behind an IPSearch.
+//
+// IPRangeMapList, *IPRangeList and *IPRangeTrie implement it, and any other
+// implementation can be plugged into an IPSearch with LoadOptions.NewContainer
+// or NewIPSearchWithContainer.
+type Container interface {
+	// Insert adds an IP range, the container keeps searchable after it.
+	Insert(ipRange *IPRange)
+	// Search returns the IP range containing the IP address, or nil.
+	Search(ip string) *IPRange
+	// Len returns the number of the IP ranges in the container.
+	Len() int
+	// Walk calls fn for every IP range in the container, it stops when fn
+	// returns false.
+	Walk(fn func(ipRange *IPRange) bool)
+}
+
+var (
+	_ Container = IPRangeMapList{}
+	_ Container = &IPRangeList{}
+	_ Container = &IPRangeTrie{}
+)
+
+// rawSearcher is implemented by the built-in containers, to search the
+// addresses which are already parsed.
+type rawSearcher interface {
+	search(ip Uint128) *IPRange
+}
+
+// disjointRanges returns the ranges of a container resolved to the disjoint
+// pieces, the nested ranges, like the ones of an IPRangeTrie, are resolved
+// to the most specific ones, which is the longest prefix match for the CIDR
+// ranges.
+func disjointRanges(c Container) []*IPRange {
+	var ipRanges []*IPRange
+	c.Walk(func(ipRange *IPRange) bool {
+		ipRanges = append(ipRanges, ipRange)
+		return true
+	})
+	// the policy never fails
+	ipRanges, _, _ = ResolveOverlaps(ipRanges, OverlapMostSpecific)
+	return ipRanges
+}
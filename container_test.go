@@ -0,0 +1,105 @@
+package ipsearch_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+// linearContainer is a naive container to test the pluggable backend.
+type linearContainer struct {
+	ranges []*ipsearch.IPRange
+}
+
+func (c *linearContainer) Insert(ip *ipsearch.IPRange) {
+	c.ranges = append(c.ranges, ip)
+}
+
+func (c *linearContainer) Search(ip string) *ipsearch.IPRange {
+	for _, r := range c.ranges {
+		if r.Type() == ipsearch.CIDR && ipsearch.IPInCIDR(ip, r.CIDR()) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (c *linearContainer) Len() int {
+	return len(c.ranges)
+}
+
+func (c *linearContainer) Walk(fn func(*ipsearch.IPRange) bool) {
+	for _, r := range c.ranges {
+		if !fn(r) {
+			return
+		}
+	}
+}
+
+func TestContainers(t *testing.T) {
+	containers := map[string]ipsearch.Container{
+		"maplist": ipsearch.NewIPRangeMapList(),
+		"list":    &ipsearch.IPRangeList{},
+		"trie":    ipsearch.NewIPRangeTrie(),
+	}
+	for name, c := range containers {
+		for _, ip := range ipsearch.NewIPRangeSlice(cidrs, ipsearch.CIDR) {
+			c.Insert(ip)
+		}
+		assert.Equal(t, len(cidrs), c.Len(), name)
+
+		for _, data := range testCIDRDataList {
+			ip := c.Search(data.ip)
+			assert.Equal(t, ip != nil, data.find, name)
+			if ip != nil {
+				assert.Equal(t, data.cidr, ip.CIDR(), name)
+			}
+		}
+
+		var walked []string
+		c.Walk(func(ip *ipsearch.IPRange) bool {
+			walked = append(walked, ip.CIDR())
+			return true
+		})
+		assert.Equal(t, expected, strings.Join(walked, "\n")+"\n", name)
+
+		n := 0
+		c.Walk(func(ip *ipsearch.IPRange) bool {
+			n++
+			return n < 3
+		})
+		assert.Equal(t, 3, n, name)
+	}
+}
+
+func TestCustomContainer(t *testing.T) {
+	opts := &ipsearch.LoadOptions{
+		NewContainer: func() ipsearch.Container { return &linearContainer{} },
+	}
+	search, _, err := ipsearch.NewIPSearchWithOptions(cidrs, ipsearch.CIDR, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(cidrs), search.Len())
+	_, ok := search.Container().(*linearContainer)
+	assert.True(t, ok)
+	for _, data := range testCIDRDataList {
+		ip := search.Search(data.ip)
+		assert.Equal(t, ip != nil, data.find)
+	}
+
+	c := &linearContainer{}
+	c.Insert(ipsearch.NewIPCIDR("10.0.0.0/8"))
+	search = ipsearch.NewIPSearchWithContainer(c)
+	assert.NotNil(t, search.Search("10.1.1.1"))
+	assert.Equal(t, 1, search.Len())
+}
+
+func TestListBackend(t *testing.T) {
+	opts := &ipsearch.LoadOptions{Backend: ipsearch.ListBackend}
+	search, _, err := ipsearch.NewIPSearchWithFileOptions(IPv4GeoFile, ipsearch.Geo, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, getFilesLineNum(IPv4GeoFile), search.Len())
+	testGeoSearch(t, search)
+}
@@ -0,0 +1,272 @@
+package ipsearch
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CSVFormat describes the columns of a delimited (CSV or TSV) file.
+//
+// A column is referenced by the name in the header, or by the 0-based index.
+// The ranges are either the CIDR column, or the Start and the End columns,
+// the End defaults to the Start for the single addresses if it is not set or
+// empty. An address is a dotted IPv4, an IPv6, or a decimal integer, the
+// integers up to 32 bits are IPv4 addresses, and the larger ones are IPv6.
+type CSVFormat struct {
+	// Comma is the field delimiter, ',' if it is zero, '\t' for TSV.
+	Comma rune
+	// Comment is the optional leading character of the comment lines.
+	Comment rune
+	// Header is true if the first row is the header.
+	Header bool
+
+	Start        string // the column of the start address
+	End          string // the column of the end address
+	CIDR         string // the column of the CIDR
+	Country      string // the column of the country code
+	ASN          string // the column of the ASN
+	Organization string // the column of the AS organization
+
+	// Metadata maps the metadata keys to the columns, the values are
+	// returned by IPRange.Meta.
+	Metadata map[string]string
+}
+
+// csvColumns is a CSVFormat with the resolved column indexes, -1 for unused.
+type csvColumns struct {
+	start, end, cidr, country, asn, org int
+	meta                                []string
+	metaCols                            []int
+}
+
+// NewIPSearchWithCSV creates a new IPSearch struct from a delimited file, see
+// ReadCSV, the IPSearch can be reloaded from the same format.
+func NewIPSearchWithCSV(path string, format *CSVFormat) (*IPSearch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return NewIPSearchFromCSV(file, format)
+}
+
+// NewIPSearchFromCSV creates a new IPSearch struct from a reader of a
+// delimited file, see NewIPSearchWithCSV. The gzip, zstd and xz compressed
+// input is decompressed on the fly.
+func NewIPSearchFromCSV(r io.Reader, format *CSVFormat) (*IPSearch, error) {
+	rc, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	ipRanges, err := ReadCSV(rc, format)
+	if err != nil {
+		return nil, err
+	}
+	// the lines of a reload are joined back, the quoted fields may have
+	// the line breaks
+	load := func(lines []string) (Container, *LoadReport, error) {
+		ipRanges, err := ReadCSV(strings.NewReader(strings.Join(lines, "\n")), format)
+		if err != nil {
+			return nil, nil, err
+		}
+		return newContainer(ipRanges, MapListBackend), nil, nil
+	}
+	return newIPSearch(newContainer(ipRanges, MapListBackend), load), nil
+}
+
+// ReadCSV reads the rows of a delimited file into the IP ranges with the
+// format, the CIDR rows are the CIDR ranges, and the others are the Geo
+// ranges. The header, the blank lines and the comment lines are skipped.
+// It returns a ParseErrors with every malformed row if any, the Line of
+// them is the line number of the row in the file.
+func ReadCSV(r io.Reader, format *CSVFormat) ([]*IPRange, error) {
+	if format == nil {
+		return nil, fmt.Errorf("%w: nil format", ErrInvalidCSV)
+	}
+	reader := csv.NewReader(r)
+	if format.Comma != 0 {
+		reader.Comma = format.Comma
+	}
+	reader.Comment = format.Comment
+	reader.FieldsPerRecord = -1
+
+	var header []string
+	if format.Header {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCSV, err)
+		}
+		header = row
+		if len(header) > 0 {
+			header[0] = strings.TrimPrefix(header[0], utf8BOM)
+		}
+	}
+	cols, err := format.columns(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var ipRanges []*IPRange
+	var errs ParseErrors
+	strs := make(map[string]string)
+	metas := make(metadataInterner)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			errs = append(errs, &LineError{Line: parseErr.StartLine, Err: fmt.Errorf("%w: %v", ErrInvalidCSV, parseErr.Err)})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		ipRange, err := cols.parse(row, strs, metas)
+		if err != nil {
+			text := strings.Join(row, string(reader.Comma))
+			errs = append(errs, &LineError{Line: line, Text: text, Err: err})
+			continue
+		}
+		ipRanges = append(ipRanges, ipRange)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return ipRanges, nil
+}
+
+// columns resolves the columns of the format with the header.
+func (f *CSVFormat) columns(header []string) (*csvColumns, error) {
+	index := func(col string) (int, error) {
+		if col == "" {
+			return -1, nil
+		}
+		for i, name := range header {
+			if strings.TrimSpace(name) == col {
+				return i, nil
+			}
+		}
+		if i, err := strconv.Atoi(col); err == nil && i >= 0 {
+			return i, nil
+		}
+		return -1, fmt.Errorf("%w: unknown column %q", ErrInvalidCSV, col)
+	}
+
+	cols := &csvColumns{}
+	var err error
+	for _, c := range []struct {
+		col string
+		idx *int
+	}{
+		{f.Start, &cols.start},
+		{f.End, &cols.end},
+		{f.CIDR, &cols.cidr},
+		{f.Country, &cols.country},
+		{f.ASN, &cols.asn},
+		{f.Organization, &cols.org},
+	} {
+		if *c.idx, err = index(c.col); err != nil {
+			return nil, err
+		}
+	}
+	if (cols.cidr < 0) == (cols.start < 0) || (cols.cidr >= 0 && cols.end >= 0) {
+		return nil, fmt.Errorf("%w: either the CIDR or the start column is required", ErrInvalidCSV)
+	}
+
+	for key, col := range f.Metadata {
+		i, err := index(col)
+		if err != nil {
+			return nil, err
+		}
+		cols.meta = append(cols.meta, key)
+		cols.metaCols = append(cols.metaCols, i)
+	}
+	return cols, nil
+}
+
+// parse parses a row into an IP range, the country, the organization and
+// the metadata values are interned with strs, and the metadata with metas.
+func (c *csvColumns) parse(row []string, strs map[string]string, metas metadataInterner) (*IPRange, error) {
+	field := func(i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	// the fields are the substrings of the record, clone them to release it
+	intern := func(i int) string {
+		return internString(strs, field(i))
+	}
+
+	var ipRange *IPRange
+	if c.cidr >= 0 {
+		var err error
+		if ipRange, err = ParseCIDR(field(c.cidr)); err != nil {
+			return nil, err
+		}
+		ipRange.cidr = strings.Clone(ipRange.cidr)
+	} else {
+		start, err := parseCSVAddr(field(c.start))
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if s := field(c.end); s != "" {
+			if end, err = parseCSVAddr(s); err != nil {
+				return nil, err
+			}
+		}
+		if end.Less(start) || start.IsIPv4() != end.IsIPv4() {
+			return nil, fmt.Errorf("%w: bad range %s - %s", ErrInvalidCSV, ipToStr(start), ipToStr(end))
+		}
+		ipRange = &IPRange{
+			rangeType: Geo,
+			bucket:    bucketOf(start),
+			start:     start,
+			end:       end,
+		}
+	}
+
+	ipRange.country = intern(c.country)
+	if asn := field(c.asn); asn != "" {
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad ASN %q", ErrInvalidCSV, asn)
+		}
+		ipRange.asn = uint32(n)
+	}
+	ipRange.org = intern(c.org)
+	if len(c.meta) > 0 {
+		kvs := make([]string, 0, 2*len(c.meta))
+		for i, key := range c.meta {
+			kvs = append(kvs, key, intern(c.metaCols[i]))
+		}
+		ipRange.meta = metas.intern(newMetadata(kvs...))
+	}
+	return ipRange, nil
+}
+
+// parseCSVAddr parses a dotted IPv4, an IPv6, or a decimal integer address.
+func parseCSVAddr(s string) (Uint128, error) {
+	if n, ok := parseDecimal128(s); ok {
+		if n.Hi == 0 && n.Lo <= 0xFFFFFFFF {
+			return ipv4To128(uint32(n.Lo)), nil
+		}
+		return n, nil
+	}
+	return ParseIP(s)
+}
@@ -0,0 +1,173 @@
+package ipsearch_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+const inventoryCSV = "\uFEFFsite,rack,owner,range,country,asn,\"risk, score\"\n" +
+	"# the inventory export\n" +
+	"sha1,r01,\"Ops, Shanghai\",1.0.1.0/24,CN,AS4134,0.2\n" +
+	"fra2,r07,\"web \"\"edge\"\"\nteam\",2001:db8::/32,DE,3320,0.9\n" +
+	"\n" +
+	"sjc1,r12,infra,8.8.8.0/24,US,15169,\n"
+
+func TestCSV(t *testing.T) {
+	format := &ipsearch.CSVFormat{
+		Header:   true,
+		Comment:  '#',
+		CIDR:     "range",
+		Country:  "country",
+		ASN:      "asn",
+		Metadata: map[string]string{"site": "site", "owner": "2", "risk": "risk, score"},
+	}
+	search, err := ipsearch.NewIPSearchFromCSV(strings.NewReader(inventoryCSV), format)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, search.Len())
+
+	ip := search.Search("1.0.1.1")
+	assert.NotNil(t, ip)
+	assert.Equal(t, ipsearch.CIDR, ip.Type())
+	assert.Equal(t, "1.0.1.0/24", ip.CIDR())
+	assert.Equal(t, "CN", ip.Country())
+	assert.Equal(t, uint32(4134), ip.ASN())
+	assert.Equal(t, "sha1", ip.Meta("site"))
+	assert.Equal(t, "Ops, Shanghai", ip.Meta("owner"))
+	assert.Equal(t, "0.2", ip.Meta("risk"))
+	assert.Equal(t, "", ip.Meta("rack"))
+
+	ip = search.Search("2001:db8::1")
+	assert.NotNil(t, ip)
+	assert.Equal(t, "web \"edge\"\nteam", ip.Meta("owner"))
+	assert.Equal(t, uint32(3320), ip.ASN())
+
+	// the empty values are not set
+	assert.Equal(t, "", search.Search("8.8.8.8").Meta("risk"))
+	assert.Nil(t, search.Search("9.9.9.9"))
+
+	// the lines of the file are joined back to reload
+	_, err = search.Reload(strings.Split(strings.Replace(inventoryCSV, "CN", "HK", 1), "\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "HK", search.Search("1.0.1.1").Country())
+	assert.Equal(t, "web \"edge\"\nteam", search.Search("2001:db8::1").Meta("owner"))
+}
+
+func TestCSVReleasesRecords(t *testing.T) {
+	// every row has a distinct owner and a large unused column
+	var b strings.Builder
+	padding := strings.Repeat("x", 64<<10)
+	for i := 0; i < 64; i++ {
+		fmt.Fprintf(&b, "10.0.%d.0/24,owner%d,%s\n", i, i, padding)
+	}
+	data := b.String()
+	format := &ipsearch.CSVFormat{CIDR: "0", Metadata: map[string]string{"owner": "1"}}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	search, err := ipsearch.NewIPSearchFromCSV(strings.NewReader(data), format)
+	assert.Nil(t, err)
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	// the ranges keep the clones of the fields, not the records
+	assert.Less(t, int64(after.HeapAlloc)-int64(before.HeapAlloc), int64(1<<20))
+	assert.Equal(t, "owner7", search.Search("10.0.7.1").Meta("owner"))
+	assert.Equal(t, "10.0.7.0/24", search.Search("10.0.7.1").CIDR())
+	runtime.KeepAlive(data)
+	runtime.KeepAlive(search)
+}
+
+func TestCSVRange(t *testing.T) {
+	tsv := "16777472\t16778239\tCN\tChinanet\n" +
+		"1.0.16.0\t1.0.31.255\tJP\tARTERIA\n" +
+		"42540766411282592856903984951653826560\t42540766490510755371168322545197776895\tNL\tdocumentation\n" +
+		"8.8.8.8\t\tUS\tGoogle DNS\n" +
+		"2.255.255.0\t4.0.0.255\tEU\tcrossing\n"
+	format := &ipsearch.CSVFormat{
+		Comma:        '\t',
+		Start:        "0",
+		End:          "1",
+		Country:      "2",
+		Organization: "3",
+	}
+	ipRanges, err := ipsearch.ReadCSV(strings.NewReader(tsv), format)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(ipRanges))
+
+	path := filepath.Join(t.TempDir(), "ranges.tsv")
+	assert.Nil(t, os.WriteFile(path, []byte(tsv), 0o644))
+	search, err := ipsearch.NewIPSearchWithCSV(path, format)
+	assert.Nil(t, err)
+	for ip, country := range map[string]string{
+		"1.0.1.0":        "CN",
+		"1.0.3.255":      "CN",
+		"1.0.20.1":       "JP",
+		"2001:db8::1":    "NL",
+		"8.8.8.8":        "US",
+		"3.1.2.3":        "EU",
+		"4.0.0.255":      "EU",
+		"2.255.255.0":    "EU",
+		"::ffff:1.0.2.1": "CN",
+	} {
+		found := search.Search(ip)
+		if assert.NotNil(t, found, ip) {
+			assert.Equal(t, country, found.Country(), ip)
+			assert.Equal(t, ipsearch.Geo, found.Type(), ip)
+		}
+	}
+	assert.Equal(t, "Chinanet", search.Search("1.0.2.1").Organization())
+	assert.Nil(t, search.Search("8.8.8.9"))
+	assert.Nil(t, search.Search("1.0.0.255"))
+
+	// the reload keeps the format
+	_, err = search.ReloadFromFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "JP", search.Search("1.0.20.1").Country())
+}
+
+func TestCSVInvalid(t *testing.T) {
+	for name, format := range map[string]*ipsearch.CSVFormat{
+		"no range":       {Country: "0"},
+		"both":           {CIDR: "0", Start: "1"},
+		"cidr and end":   {CIDR: "0", End: "1"},
+		"unknown column": {CIDR: "range"},
+		"unknown meta":   {CIDR: "0", Metadata: map[string]string{"site": "site"}},
+		"nil":            nil,
+	} {
+		_, err := ipsearch.ReadCSV(strings.NewReader("1.0.0.0/24\n"), format)
+		assert.True(t, errors.Is(err, ipsearch.ErrInvalidCSV), name)
+	}
+	_, err := ipsearch.NewIPSearchFromCSV(strings.NewReader("1.0.0.0/24\n"), nil)
+	assert.True(t, errors.Is(err, ipsearch.ErrInvalidCSV))
+	_, err = ipsearch.NewIPTableFromCSV(strings.NewReader("1.0.0.0/24\n"), nil, func(*ipsearch.IPRange) int { return 0 })
+	assert.True(t, errors.Is(err, ipsearch.ErrInvalidCSV))
+
+	rows := "1.0.0.0,1.0.0.255,CN,AS1\n" +
+		"1.0.1.0,bad,CN,\n" +
+		"1.0.2.255,1.0.2.0,CN,\n" +
+		"1.0.3.0,2001:db8::,CN,\n" +
+		"1.0.4.0,1.0.4.255,CN,ASX\n" +
+		"1.0.5.0,1.0.5.255,\"CN\n"
+	_, err = ipsearch.ReadCSV(strings.NewReader(rows), &ipsearch.CSVFormat{Start: "0", End: "1", Country: "2", ASN: "3"})
+	var errs ipsearch.ParseErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, 5, len(errs))
+	for i, e := range errs {
+		assert.Equal(t, i+2, e.Line)
+	}
+	assert.True(t, errors.Is(errs[0], ipsearch.ErrInvalidIP))
+	assert.True(t, errors.Is(errs[4], ipsearch.ErrInvalidCSV))
+
+	_, err = ipsearch.NewIPSearchWithCSV("not-exist-file", &ipsearch.CSVFormat{CIDR: "0"})
+	assert.NotNil(t, err)
+}
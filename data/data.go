@@ -0,0 +1,45 @@
+// Package data embeds the bundled datasets of ipsearch into the binary, so
+// the data files don't need to be shipped alongside it.
+//
+// Importing this package adds the size of the datasets (about 4MB) to the
+// binary, run update.sh to refresh them.
+package data
+
+import (
+	"bytes"
+	_ "embed"
+	"time"
+
+	"github.com/haoel/ipsearch"
+)
+
+//go:embed china_ip_list.txt
+var chinaIPList []byte
+
+//go:embed asn-country-ipv4.csv
+var asnCountryIPv4 []byte
+
+// DefaultChinaCIDR creates a new IPSearch of the bundled China IP CIDR list.
+func DefaultChinaCIDR() *ipsearch.IPSearch {
+	return newIPSearch(chinaIPList, ipsearch.CIDR)
+}
+
+// DefaultCountryGeo creates a new IPSearch of the bundled IPv4 country Geo list.
+func DefaultCountryGeo() *ipsearch.IPSearch {
+	return newIPSearch(asnCountryIPv4, ipsearch.Geo)
+}
+
+// UpdatedAt returns the time the bundled datasets were downloaded.
+func UpdatedAt() time.Time {
+	t, _ := time.Parse(time.RFC3339, updated)
+	return t
+}
+
+func newIPSearch(data []byte, rangeType ipsearch.RangeType) *ipsearch.IPSearch {
+	// reading from the memory never fails
+	search, err := ipsearch.NewIPSearchFromReader(bytes.NewReader(data), rangeType)
+	if err != nil {
+		panic(err)
+	}
+	return search
+}
@@ -0,0 +1,26 @@
+package data_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch/data"
+)
+
+func TestDefaultChinaCIDR(t *testing.T) {
+	search := data.DefaultChinaCIDR()
+	assert.NotNil(t, search.Search("114.114.114.114"))
+	assert.Nil(t, search.Search("8.8.8.8"))
+}
+
+func TestDefaultCountryGeo(t *testing.T) {
+	search := data.DefaultCountryGeo()
+	ip := search.Search("8.8.8.8")
+	assert.NotNil(t, ip)
+	assert.Equal(t, "US", ip.Country())
+}
+
+func TestUpdatedAt(t *testing.T) {
+	assert.False(t, data.UpdatedAt().IsZero())
+}
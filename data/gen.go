@@ -0,0 +1,39 @@
+//go:build ignore
+
+// gen.go generates updated.go with the time the bundled datasets were
+// downloaded, which is given as an RFC 3339 argument. It is run by update.sh
+// right after the download, e.g.
+//
+//	go run gen.go 2024-01-02T03:04:05Z
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const tmpl = `// Code generated by gen.go; DO NOT EDIT.
+
+package data
+
+// updated is the time the bundled datasets were downloaded.
+const updated = %q
+`
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: go run gen.go <time>")
+		os.Exit(2)
+	}
+	updated, err := time.Parse(time.RFC3339, os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	src := fmt.Sprintf(tmpl, updated.UTC().Format(time.RFC3339))
+	if err := os.WriteFile("updated.go", []byte(src), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
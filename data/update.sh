@@ -5,4 +5,7 @@ BASEDIR=$(dirname "$0")
 cd $BASEDIR
 
 curl -LO https://raw.githubusercontent.com/17mon/china_ip_list/master/china_ip_list.txt
-curl -LO https://cdn.jsdelivr.net/npm/@ip-location-db/asn-country/asn-country-ipv4.csv
\ No newline at end of file
+curl -LO https://cdn.jsdelivr.net/npm/@ip-location-db/asn-country/asn-country-ipv4.csv
+
+# the time of the embedded datasets, see data.go
+go run gen.go "$(date -u +%Y-%m-%dT%H:%M:%SZ)"
@@ -0,0 +1,95 @@
+package ipsearch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// delegatedDateLayout is the layout of the dates of the delegated stats files.
+const delegatedDateLayout = "20060102"
+
+// NewDelegated creates a new Geo range from a line of an RIR delegated stats
+// file, it returns nil for a malformed line or a line without an IP range,
+// see ParseDelegatedLine.
+func NewDelegated(line string) *IPRange {
+	ipRange, err := ParseDelegatedLine(line)
+	if err != nil {
+		log.Debugf("Skip the delegated stats line: %v", err)
+		return nil
+	}
+	return ipRange
+}
+
+// ParseDelegatedLine parses a line of an RIR delegated stats file, in the
+// pipe-delimited "registry|cc|type|start|value|date|status[|...]" format:
+//
+//	apnic|CN|ipv4|1.0.1.0|256|20110414|allocated
+//	arin|US|ipv4|3.0.0.0|4194304|19880223|allocated|e5e3b9c13678dfc483fb1f819d70883c
+//	ripencc|NL|ipv6|2001:610::|32|19990819|allocated
+//
+// The value of an ipv4 record is the count of the addresses, which is not
+// always a power of two, and the value of an ipv6 record is the prefix
+// length. The ranges are the Geo ranges, with the Registry, Status and Date.
+//
+// The version line, the summary lines, the asn records and the comments have
+// no IP range, it returns a nil range and a nil error for them.
+func ParseDelegatedLine(line string) (*IPRange, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return nil, nil
+	}
+	fields := strings.Split(line, "|")
+	switch {
+	case line[0] >= '0' && line[0] <= '9':
+		return nil, nil // the version line
+	case len(fields) >= 6 && fields[5] == "summary":
+		return nil, nil
+	case len(fields) < 7:
+		return nil, fmt.Errorf("%w: too few fields %q", ErrInvalidDelegated, line)
+	}
+
+	var start, end Uint128
+	switch fields[2] {
+	case "asn":
+		return nil, nil
+	case "ipv4":
+		ip, ok := parseIPv4(fields[3])
+		if !ok {
+			return nil, fmt.Errorf("%w: bad IP %q", ErrInvalidDelegated, fields[3])
+		}
+		count, err := strconv.ParseUint(fields[4], 10, 64)
+		if err != nil || count == 0 || uint64(ip)+count-1 > 0xFFFFFFFF {
+			return nil, fmt.Errorf("%w: bad count %q", ErrInvalidDelegated, line)
+		}
+		start, end = ipv4To128(ip), ipv4To128(uint32(uint64(ip)+count-1))
+	case "ipv6":
+		ip, ok := parseIPv6(fields[3])
+		if !ok || ip.IsIPv4() {
+			return nil, fmt.Errorf("%w: bad IP %q", ErrInvalidDelegated, fields[3])
+		}
+		prefixLen, ok := parseMask(fields[4], 128)
+		if !ok {
+			return nil, fmt.Errorf("%w: bad prefix length %q", ErrInvalidDelegated, line)
+		}
+		host := hostMask(prefixLen)
+		start, end = ip.andNot(host), ip.or(host)
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidDelegated, line)
+	}
+
+	date := fields[5]
+	if date == "00000000" {
+		date = ""
+	}
+	return &IPRange{
+		rangeType: Geo,
+		bucket:    bucketOf(start),
+		start:     start,
+		end:       end,
+		country:   strings.ToUpper(fields[1]),
+		meta:      newMetadata(metaRegistry, fields[0], metaStatus, fields[6], metaDate, date),
+	}, nil
+}
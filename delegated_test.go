@@ -0,0 +1,85 @@
+package ipsearch_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+var delegated = []string{
+	"2|apnic|20230409|71258|19830613|20230407|+1000",
+	"# a comment",
+	"apnic|*|asn|*|10934|summary",
+	"apnic|*|ipv4|*|45844|summary",
+	"apnic|*|ipv6|*|14480|summary",
+	"apnic|JP|asn|173|1|20020801|allocated",
+	"apnic|AU|ipv4|1.0.0.0|256|20110811|assigned",
+	"apnic|CN|ipv4|1.0.1.0|256|20110414|allocated",
+	"apnic|CN|ipv4|1.0.2.0|512|20110414|allocated",
+	"apnic|JP|ipv4|1.0.16.0|4096|20110412|allocated",
+	"apnic|IN|ipv4|27.100.24.0|768|20100908|allocated",
+	"apnic|CN|ipv4|114.112.0.0|393216|20100108|allocated",
+	"apnic||ipv4|223.255.254.0|512||available",
+	"apnic|CN|ipv6|240e::|20|20130613|allocated",
+	"apnic|JP|ipv6|2001:200::|35|19990813|allocated",
+}
+
+func TestDelegated(t *testing.T) {
+	search, report, err := ipsearch.NewIPSearchWithOptions(delegated, ipsearch.Delegated, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Comments)
+	assert.Equal(t, 5, report.Ignored)
+	assert.Equal(t, 9, report.RangesLoaded)
+
+	ip := search.Search("1.0.1.1")
+	assert.NotNil(t, ip)
+	assert.Equal(t, ipsearch.Geo, ip.Type())
+	assert.Equal(t, "CN", ip.Country())
+	assert.Equal(t, "apnic", ip.Registry())
+	assert.Equal(t, "allocated", ip.Status())
+	assert.Equal(t, time.Date(2011, 4, 14, 0, 0, 0, 0, time.UTC), ip.Date())
+	assert.Equal(t, "1.0.1.0,1.0.1.255,CN", ip.String())
+
+	// the counts are not always a power of two
+	ip = search.Search("27.100.26.255")
+	assert.Equal(t, "27.100.24.0 - 27.100.26.255", ip.Range())
+	assert.Nil(t, search.Search("27.100.27.0"))
+	assert.Equal(t, "114.112.0.0 - 114.117.255.255", search.Search("114.117.1.1").Range())
+
+	ip = search.Search("223.255.255.1")
+	assert.Equal(t, "", ip.Country())
+	assert.Equal(t, "available", ip.Status())
+	assert.True(t, ip.Date().IsZero())
+
+	assert.Equal(t, "CN", search.Search("240e:3b7::1").Country())
+	assert.Equal(t, "JP", search.Search("2001:200:1fff::1").Country())
+	assert.Nil(t, search.Search("2001:200:2000::1"))
+
+	// the lenient loading gets the same ranges
+	assert.Equal(t, search.Len(), ipsearch.NewIPSearch(delegated, ipsearch.Delegated).Len())
+}
+
+func TestDelegatedMalformed(t *testing.T) {
+	for _, line := range []string{
+		"apnic|CN|ipv4|1.0.1.0|256",
+		"apnic|CN|ipv4|1.0.1|256|20110414|allocated",
+		"apnic|CN|ipv4|1.0.1.0|0|20110414|allocated",
+		"apnic|CN|ipv4|255.255.255.0|257|20110414|allocated",
+		"apnic|CN|ipv6|240e::|129|20130613|allocated",
+		"apnic|CN|ipv6|::ffff:1.0.0.0|120|20130613|allocated",
+		"apnic|CN|ipv5|1.0.1.0|256|20110414|allocated",
+	} {
+		_, err := ipsearch.ParseDelegatedLine(line)
+		assert.True(t, errors.Is(err, ipsearch.ErrInvalidDelegated), line)
+		assert.Nil(t, ipsearch.NewDelegated(line), line)
+	}
+
+	_, err := ipsearch.NewIPSearchStrict(append(delegated, "apnic|CN|ipv4|bad|256|20110414|allocated"), ipsearch.Delegated)
+	var errs ipsearch.ParseErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, 1, len(errs))
+}
@@ -4,7 +4,7 @@ package main
 import (
 	"fmt"
 
-	"github.com/haoel/ipsearch"
+	"github.com/haoel/ipsearch/data"
 )
 
 func main() {
@@ -13,10 +13,8 @@ func main() {
 }
 
 func checkChinaIP() {
-	search, err := ipsearch.NewIPSearchWithFile("./data/china_ip_list.txt", ipsearch.CIDR)
-	if err != nil {
-		panic(err)
-	}
+	// the bundled datasets are embedded in the binary, it works from any directory
+	search := data.DefaultChinaCIDR()
 
 	ipStr := "114.114.114.114"
 	ip := search.Search(ipStr)
@@ -28,10 +26,9 @@ func checkChinaIP() {
 }
 
 func findIPCountry() {
-	search, err := ipsearch.NewIPSearchWithFile("./data/asn-country-ipv4.csv", ipsearch.Geo)
-	if err != nil {
-		panic(err)
-	}
+	search := data.DefaultCountryGeo()
+	fmt.Printf("Geo data updated at %s\n", data.UpdatedAt().Format("2006-01-02"))
+
 	ipStr := "8.8.8.8"
 	ip := search.Search(ipStr)
 	if ip != nil {
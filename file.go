@@ -2,13 +2,19 @@ package ipsearch
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 // ReadFile reads a ip list file and returns a slice of strings, one for each line.
+// The gzip, zstd and xz compressed files are decompressed on the fly.
 func ReadFile(filename string) ([]string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -20,22 +26,145 @@ func ReadFile(filename string) ([]string, error) {
 }
 
 // ReadFileFromURL from a URL and returns a slice of strings, one for each line.
+// The gzip, zstd and xz compressed files are decompressed on the fly.
 func ReadFileFromURL(url string) ([]string, error) {
-	resp, err := http.Get(url)
+	return ReadFileFromURLWithOptions(context.Background(), url, nil)
+}
+
+// ErrBodyTooLarge is returned when the response body exceeds HTTPOptions.MaxBodySize.
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// HTTPOptions configures how an IP range list is downloaded.
+type HTTPOptions struct {
+	// Client is the HTTP client, http.DefaultClient by default.
+	Client *http.Client
+	// Header is the custom request headers, e.g. the auth token of a
+	// private mirror.
+	Header http.Header
+	// Retries is the number of the retries after the first attempt, a
+	// request is retried on the network errors, 429 and 5xx responses.
+	Retries int
+	// Backoff is the wait before the first retry, doubled on every retry,
+	// 500ms by default.
+	Backoff time.Duration
+	// MaxBackoff caps the wait between the retries, 30s by default.
+	MaxBackoff time.Duration
+	// MaxBodySize limits the size of the response body, no limit if zero.
+	MaxBodySize int64
+}
+
+// ReadFileFromURLWithOptions reads a URL with the context and the HTTP
+// options, and returns a slice of strings, one for each line.
+func ReadFileFromURLWithOptions(ctx context.Context, url string, opts *HTTPOptions) ([]string, error) {
+	lines, _, err := fetchURL(ctx, url, opts, nil)
+	return lines, err
+}
+
+// fetchURL downloads a URL with the retries, the extra headers are added to
+// the request. The response is returned for its status and headers, its
+// body is closed, and the lines are nil unless the status is 200.
+func fetchURL(ctx context.Context, url string, opts *HTTPOptions, header http.Header) ([]string, *http.Response, error) {
+	if opts == nil {
+		opts = &HTTPOptions{}
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	backoff := opts.Backoff
+	if backoff <= 0 {
+		backoff = 500 * time.Millisecond
+	}
+	maxBackoff := opts.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+
+	for attempt := 0; ; attempt++ {
+		lines, resp, retry, err := fetchURLOnce(ctx, client, url, opts, header)
+		if err == nil || !retry || attempt >= opts.Retries {
+			return lines, resp, err
+		}
+		log.Debugf("Retry %s in %s: %v", url, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func fetchURLOnce(ctx context.Context, client *http.Client, url string, opts *HTTPOptions, header http.Header) (lines []string, resp *http.Response, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, false, err
+	}
+	for _, h := range []http.Header{opts.Header, header} {
+		for k, v := range h {
+			req.Header[k] = append([]string(nil), v...)
+		}
+	}
+
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, nil, ctx.Err() == nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Status code error: %d", resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusNotModified && header != nil:
+		return nil, resp, false, nil
+	default:
+		retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, resp, retry, fmt.Errorf("Status code error: %d", resp.StatusCode)
+	}
+
+	var body io.Reader = resp.Body
+	if opts.MaxBodySize > 0 {
+		if resp.ContentLength > opts.MaxBodySize {
+			return nil, resp, false, fmt.Errorf("%w: %d bytes", ErrBodyTooLarge, resp.ContentLength)
+		}
+		body = &limitReader{r: resp.Body, remaining: opts.MaxBodySize}
 	}
-	return readLines(resp.Body)
+	lines, err = readLines(body)
+	if err != nil {
+		return nil, resp, !errors.Is(err, ErrBodyTooLarge) && ctx.Err() == nil, err
+	}
+	return lines, resp, false, nil
+}
+
+// limitReader fails with ErrBodyTooLarge when reading more than remaining bytes.
+type limitReader struct {
+	r         io.Reader
+	remaining int64
 }
 
+func (l *limitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// readLines reads all of the lines, the compressed input is decompressed.
 func readLines(r io.Reader) ([]string, error) {
+	rc, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
 	var lines []string
-	scanner := bufio.NewScanner(r)
+	scanner := bufio.NewScanner(rc)
 	for scanner.Scan() {
 		lines = append(lines, scanner.Text())
 	}
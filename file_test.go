@@ -2,9 +2,13 @@ package ipsearch_test
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -78,3 +82,93 @@ func test(t *testing.T, cidrs []string, err error) {
 	assert.Nil(t, err)
 	assert.Equal(t, len(cidrs), FileLines)
 }
+
+func TestURLWithOptions(t *testing.T) {
+	var attempts int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(strings.Join(cidrs, "\n")))
+	})
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(strings.Join(cidrs, "\n")))
+	})
+	mux.HandleFunc("/hang", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		// no Content-Length with the flushes
+		for i := 0; i < 100; i++ {
+			w.Write([]byte("1.0.1.0/24\n"))
+			w.(http.Flusher).Flush()
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	ctx := context.Background()
+
+	// custom headers
+	lines, err := ipsearch.ReadFileFromURLWithOptions(ctx, ts.URL+"/auth", nil)
+	assert.NotNil(t, err)
+	assert.Nil(t, lines)
+	opts := &ipsearch.HTTPOptions{Header: http.Header{"Authorization": {"Bearer token"}}}
+	lines, err = ipsearch.ReadFileFromURLWithOptions(ctx, ts.URL+"/auth", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, cidrs, lines)
+
+	// retries
+	opts = &ipsearch.HTTPOptions{Retries: 1, Backoff: time.Millisecond}
+	_, err = ipsearch.ReadFileFromURLWithOptions(ctx, ts.URL+"/flaky", opts)
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	atomic.StoreInt32(&attempts, 0)
+	opts.Retries = 5
+	lines, err = ipsearch.ReadFileFromURLWithOptions(ctx, ts.URL+"/flaky", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, cidrs, lines)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	// 4xx is not retried
+	atomic.StoreInt32(&attempts, 0)
+	_, err = ipsearch.ReadFileFromURLWithOptions(ctx, ts.URL+"/not-exist", opts)
+	assert.Contains(t, err.Error(), "404")
+
+	// the context and the client timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = ipsearch.ReadFileFromURLWithOptions(timeoutCtx, ts.URL+"/hang", opts)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	opts = &ipsearch.HTTPOptions{Client: &http.Client{Timeout: 50 * time.Millisecond}}
+	_, err = ipsearch.ReadFileFromURLWithOptions(ctx, ts.URL+"/hang", opts)
+	assert.NotNil(t, err)
+
+	// max body size
+	opts = &ipsearch.HTTPOptions{MaxBodySize: 100}
+	_, err = ipsearch.ReadFileFromURLWithOptions(ctx, ts.URL+"/large", opts)
+	assert.True(t, errors.Is(err, ipsearch.ErrBodyTooLarge))
+	_, err = ipsearch.ReadFileFromURLWithOptions(ctx, ts.URL+"/auth",
+		&ipsearch.HTTPOptions{MaxBodySize: 10, Header: http.Header{"Authorization": {"Bearer token"}}})
+	assert.True(t, errors.Is(err, ipsearch.ErrBodyTooLarge))
+	opts.MaxBodySize = 1100
+	lines, err = ipsearch.ReadFileFromURLWithOptions(ctx, ts.URL+"/large", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(lines))
+
+	search, err := ipsearch.NewIPSearchFromURLContext(ctx, ts.URL+"/large", ipsearch.CIDR, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, search.Search("1.0.1.1"))
+	_, err = ipsearch.NewIPSearchFromURLContext(timeoutCtx, ts.URL+"/hang", ipsearch.CIDR, nil)
+	assert.NotNil(t, err)
+
+	search = ipsearch.NewIPSearch(nil, ipsearch.CIDR)
+	_, err = search.ReloadFromURLContext(ctx, ts.URL+"/large", nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, search.Search("1.0.1.1"))
+}
@@ -3,9 +3,11 @@ module github.com/haoel/ipsearch
 go 1.20
 
 require (
+	github.com/klauspost/compress v1.16.7
 	github.com/sirupsen/logrus v1.9.0
 	github.com/stretchr/testify v1.7.0
 	github.com/t-tomalak/logrus-easy-formatter v0.0.0-20190827215021-c074f06c5816
+	github.com/ulikunitz/xz v0.5.12
 )
 
 require (
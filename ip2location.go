@@ -0,0 +1,155 @@
+package ipsearch
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NewIP2Location creates a new IP range from an IP2Location LITE CSV line, it
+// returns nil for a malformed line, see ParseIP2LocationLine.
+func NewIP2Location(line string) *IPRange {
+	ipRange, err := ParseIP2LocationLine(line)
+	if err != nil {
+		log.Debugf("Skip the IP2Location line: %v", err)
+		return nil
+	}
+	return ipRange
+}
+
+// ParseIP2LocationLine parses an IP2Location LITE CSV line, the fields are
+// quoted, and the start and the end are the decimal integers:
+//
+//	"16777216","16777471","US","United States of America","California","Los Angeles"
+//	"16777216","16777471","1.0.0.0/24","13335","CloudFlare Inc."
+//
+// The IPv6 files have the 128-bit integers, where the IPv4 addresses are the
+// IPv4-mapped ones. The country code "-" (no data) is loaded as empty. The
+// country name, the region and the city are kept as the metadata, and the
+// ASN files (with the CIDR as the third field) have the ASN and the AS name.
+func ParseIP2LocationLine(line string) (*IPRange, error) {
+	fields, ok := splitQuoted(line)
+	if !ok || len(fields) < 3 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidIP2Location, line)
+	}
+	start, ok1 := parseDecimal128(fields[0])
+	end, ok2 := parseDecimal128(fields[1])
+	if !ok1 || !ok2 || end.Less(start) {
+		return nil, fmt.Errorf("%w: bad range %q", ErrInvalidIP2Location, line)
+	}
+	// the IPv4 files have the 32-bit integers
+	if end.Hi == 0 && end.Lo <= 0xFFFFFFFF {
+		start, end = ipv4To128(uint32(start.Lo)), ipv4To128(uint32(end.Lo))
+	}
+	if start.IsIPv4() != end.IsIPv4() {
+		return nil, fmt.Errorf("%w: mixed IPv4 and IPv6 %q", ErrInvalidIP2Location, line)
+	}
+
+	ipRange := &IPRange{
+		rangeType: IP2Location,
+		bucket:    bucketOf(start),
+		start:     start,
+		end:       end,
+	}
+	field := func(i int) string {
+		if i < len(fields) && fields[i] != "-" {
+			return fields[i]
+		}
+		return ""
+	}
+
+	// the ASN file: ip_from, ip_to, cidr, asn, as
+	if strings.IndexByte(fields[2], '/') >= 0 {
+		ipRange.cidr = fields[2]
+		if asn, ok := parseDecimal128(field(3)); ok && asn.Hi == 0 && asn.Lo <= 0xFFFFFFFF {
+			ipRange.asn = uint32(asn.Lo)
+		}
+		ipRange.org = field(4)
+		return ipRange, nil
+	}
+
+	ipRange.country = field(2)
+	ipRange.meta = newMetadata(metaCountryName, field(3), metaRegion, field(4), metaCity, field(5))
+	return ipRange, nil
+}
+
+// splitQuoted splits a CSV line with the optionally quoted fields, a quote in
+// a quoted field is escaped as two quotes.
+func splitQuoted(line string) ([]string, bool) {
+	line = strings.TrimRight(line, "\r")
+	var fields []string
+	for {
+		if strings.HasPrefix(line, `"`) {
+			var b strings.Builder
+			i := 1
+			for {
+				j := strings.IndexByte(line[i:], '"')
+				if j < 0 {
+					return nil, false
+				}
+				b.WriteString(line[i : i+j])
+				i += j + 1
+				if i < len(line) && line[i] == '"' {
+					b.WriteByte('"')
+					i++
+					continue
+				}
+				break
+			}
+			fields = append(fields, b.String())
+			line = line[i:]
+			if line == "" {
+				return fields, true
+			}
+			if line[0] != ',' {
+				return nil, false
+			}
+			line = line[1:]
+			continue
+		}
+
+		i := strings.IndexByte(line, ',')
+		if i < 0 {
+			return append(fields, line), true
+		}
+		fields = append(fields, line[:i])
+		line = line[i+1:]
+	}
+}
+
+// parseDecimal128 parses a decimal 128-bit unsigned integer.
+func parseDecimal128(s string) (Uint128, bool) {
+	var n Uint128
+	if s == "" || len(s) > 39 {
+		return n, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return n, false
+		}
+		// n = n*8 + n*2 + d, checking the overflow of every step
+		if n.Hi>>61 != 0 {
+			return n, false
+		}
+		n8 := Uint128{n.Hi<<3 | n.Lo>>61, n.Lo << 3}
+		n2 := Uint128{n.Hi<<1 | n.Lo>>63, n.Lo << 1}
+		n10 := add128(n8, n2)
+		next := add128(n10, Uint128{0, uint64(s[i] - '0')})
+		if n10.Less(n8) || next.Less(n10) {
+			return n, false
+		}
+		n = next
+	}
+	return n, true
+}
+
+// add128 returns u+v, wrapping around on overflow.
+func add128(u, v Uint128) Uint128 {
+	lo := u.Lo + v.Lo
+	hi := u.Hi + v.Hi
+	if lo < u.Lo {
+		hi++
+	}
+	return Uint128{hi, lo}
+}
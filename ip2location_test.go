@@ -0,0 +1,90 @@
+package ipsearch_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+var ip2location = []string{
+	`"0","16777215","-","-","-","-"`,
+	`"16777216","16777471","US","United States of America","California","Los Angeles"`,
+	`"16777472","16778239","CN","China","Fujian","Fuzhou"`,
+	`"134744064","134744319","US","United States of America","California","Mountain View"`,
+	`"3758096384","4294967295","-","-","-","-"`,
+}
+
+var ip2location6 = []string{
+	`"0","281470681743359","-","-"`,
+	`"281470698520576","281470698520831","US","United States of America"`,
+	`"42540766411282592856903984951653826560","42540766490510755371168322545197776895","US","United States of America"`,
+	`"340282366920938463463374607431768211455","340282366920938463463374607431768211455","-","-"`,
+}
+
+var ip2locationASN = []string{
+	`"16777216","16777471","1.0.0.0/24","13335","CloudFlare, Inc."`,
+	`"134744064","134744319","8.8.8.0/24","15169","Google LLC"`,
+}
+
+func TestIP2Location(t *testing.T) {
+	search, err := ipsearch.NewIPSearchStrict(ip2location, ipsearch.IP2Location)
+	assert.Nil(t, err)
+
+	ip := search.Search("1.0.0.1")
+	assert.NotNil(t, ip)
+	assert.Equal(t, ipsearch.IP2Location, ip.Type())
+	assert.Equal(t, "US", ip.Country())
+	assert.Equal(t, "California", ip.Region())
+	assert.Equal(t, "Los Angeles", ip.City())
+	assert.Equal(t, "1.0.0.0,1.0.0.255,US", ip.String())
+
+	assert.Equal(t, "Fuzhou", search.Search("1.0.3.255").City())
+	assert.Equal(t, "Mountain View", search.Search("8.8.8.8").City())
+	assert.Equal(t, "", search.Search("0.0.0.1").Country())
+	assert.Equal(t, "", search.Search("0.0.0.1").Region())
+	assert.Nil(t, search.Search("1.0.4.0"))
+
+	search, err = ipsearch.NewIPSearchStrict(ip2location6, ipsearch.IP2Location)
+	assert.Nil(t, err)
+	assert.Equal(t, "US", search.Search("1.0.0.1").Country())
+	assert.Equal(t, "US", search.Search("2001:db8::8888").Country())
+	assert.Equal(t, "", search.Search("::1").Country())
+	assert.NotNil(t, search.Search("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"))
+	assert.Nil(t, search.Search("2001:db9::1"))
+
+	search, err = ipsearch.NewIPSearchStrict(ip2locationASN, ipsearch.IP2Location)
+	assert.Nil(t, err)
+	ip = search.Search("1.0.0.1")
+	assert.Equal(t, "1.0.0.0/24", ip.CIDR())
+	assert.Equal(t, uint32(13335), ip.ASN())
+	assert.Equal(t, "CloudFlare, Inc.", ip.Organization())
+}
+
+func TestIP2LocationMalformed(t *testing.T) {
+	for _, line := range []string{
+		``,
+		`"16777216","16777471"`,
+		`"16777216,"16777471","US"`,
+		`"16777216","16777471"x,"US"`,
+		`"16777471","16777216","US"`,
+		`"1.0.0.0","1.0.0.255","US"`,
+		`"16777216","340282366920938463463374607431768211456","US"`,
+		`"0","281470681743360","US"`,
+	} {
+		_, err := ipsearch.ParseIP2LocationLine(line)
+		assert.True(t, errors.Is(err, ipsearch.ErrInvalidIP2Location), line)
+		assert.Nil(t, ipsearch.NewIP2Location(line), line)
+	}
+
+	// the quotes are optional, and escaped by doubling
+	ip, err := ipsearch.ParseIP2LocationLine(`16777216,16777471,US,"United States ""USA"""`)
+	assert.Nil(t, err)
+	assert.Equal(t, "US", ip.Country())
+
+	// the lenient loading skips the malformed lines
+	search := ipsearch.NewIPSearch(append([]string{"bad line"}, ip2location...), ipsearch.IP2Location)
+	assert.Equal(t, ipsearch.NewIPSearch(ip2location, ipsearch.IP2Location).Len(), search.Len())
+}
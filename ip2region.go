@@ -0,0 +1,130 @@
+package ipsearch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The layout of an ip2region xdb file (version 2), all of the integers are
+// little endian:
+//
+//	header          256 bytes, the uint16 version, the uint16 index policy,
+//	                the uint32 creation time, and the uint32 offsets of the
+//	                first and the last segment index
+//	vector index    256*256 of the uint32 first and last segment index
+//	                offsets of the first two octets
+//	region data     "country|area|province|city|isp", "0" for unknown
+//	segment index   the uint32 start and end IPs, the uint16 length and the
+//	                uint32 offset of the region data, for each segment
+const (
+	xdbVersion         = 2
+	xdbHeaderSize      = 256
+	xdbVectorIndexSize = 256 * 256 * 8
+	xdbSegmentSize     = 14
+)
+
+// ErrBadIP2Region is returned when an ip2region xdb file is corrupted or unsupported.
+var ErrBadIP2Region = errors.New("bad ip2region xdb file")
+
+// NewIPSearchWithIP2Region creates a new IPSearch struct from an ip2region xdb
+// file, see ReadIP2Region, the IPSearch cannot be reloaded.
+func NewIPSearchWithIP2Region(path string) (*IPSearch, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ipRanges, err := ReadIP2Region(data)
+	if err != nil {
+		return nil, err
+	}
+	return newIPSearch(newContainer(ipRanges, MapListBackend), nil), nil
+}
+
+// NewIPSearchFromIP2Region creates a new IPSearch struct from a reader of an
+// ip2region xdb file, see NewIPSearchWithIP2Region.
+func NewIPSearchFromIP2Region(r io.Reader) (*IPSearch, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ipRanges, err := ReadIP2Region(data)
+	if err != nil {
+		return nil, err
+	}
+	return newIPSearch(newContainer(ipRanges, MapListBackend), nil), nil
+}
+
+// ReadIP2Region reads the segments of an ip2region xdb file (version 2, IPv4)
+// into the IP ranges, the adjacent segments with the same region data are
+// merged. The country is the country name, and the province, the city and
+// the ISP are the Region, City and ISP of the ranges.
+func ReadIP2Region(data []byte) ([]*IPRange, error) {
+	if len(data) < xdbHeaderSize+xdbVectorIndexSize {
+		return nil, fmt.Errorf("%w: file too short", ErrBadIP2Region)
+	}
+	if version := binary.LittleEndian.Uint16(data); version != xdbVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadIP2Region, version)
+	}
+	first := int(binary.LittleEndian.Uint32(data[8:]))
+	last := int(binary.LittleEndian.Uint32(data[12:]))
+	if first < xdbHeaderSize+xdbVectorIndexSize || last < first ||
+		last+xdbSegmentSize > len(data) || (last-first)%xdbSegmentSize != 0 {
+		return nil, fmt.Errorf("%w: bad segment index", ErrBadIP2Region)
+	}
+
+	regions := make(map[uint32]*IPRange)
+	var ipRanges []*IPRange
+	var prev *IPRange
+	var prevPtr uint32
+	for off := first; off <= last; off += xdbSegmentSize {
+		seg := data[off : off+xdbSegmentSize]
+		start := binary.LittleEndian.Uint32(seg)
+		end := binary.LittleEndian.Uint32(seg[4:])
+		size := int(binary.LittleEndian.Uint16(seg[8:]))
+		ptr := binary.LittleEndian.Uint32(seg[10:])
+		if end < start || int(ptr)+size > len(data) {
+			return nil, fmt.Errorf("%w: bad segment at %d", ErrBadIP2Region, off)
+		}
+
+		if prev != nil && ptr == prevPtr && prev.end.Uint32()+1 == start {
+			prev.end = ipv4To128(end)
+			continue
+		}
+
+		region, ok := regions[ptr]
+		if !ok {
+			region = newIP2RegionData(string(data[ptr : int(ptr)+size]))
+			regions[ptr] = region
+		}
+		prev = &IPRange{
+			rangeType: IP2Region,
+			bucket:    bucketOf(ipv4To128(start)),
+			start:     ipv4To128(start),
+			end:       ipv4To128(end),
+			country:   region.country,
+			meta:      region.meta,
+		}
+		prevPtr = ptr
+		ipRanges = append(ipRanges, prev)
+	}
+	return ipRanges, nil
+}
+
+// newIP2RegionData parses the "country|area|province|city|isp" region data.
+func newIP2RegionData(region string) *IPRange {
+	fields := strings.Split(region, "|")
+	field := func(i int) string {
+		if i < len(fields) && fields[i] != "0" {
+			return fields[i]
+		}
+		return ""
+	}
+	return &IPRange{
+		country: field(0),
+		meta:    newMetadata(metaRegion, field(2), metaCity, field(3), metaISP, field(4)),
+	}
+}
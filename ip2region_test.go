@@ -0,0 +1,135 @@
+package ipsearch_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+type xdbSegment struct {
+	start, end string
+	region     string
+}
+
+// buildXDB builds an ip2region xdb file (version 2), the segments must be
+// sorted and must not cross the first two octets.
+func buildXDB(segments []xdbSegment) []byte {
+	const vectorIndex = 256
+	data := make([]byte, 256+256*256*8)
+	binary.LittleEndian.PutUint16(data, 2)
+	binary.LittleEndian.PutUint16(data[2:], 1)
+
+	regions := make(map[string]uint32)
+	for _, seg := range segments {
+		if _, ok := regions[seg.region]; !ok {
+			regions[seg.region] = uint32(len(data))
+			data = append(data, seg.region...)
+		}
+	}
+
+	first := uint32(len(data))
+	for _, seg := range segments {
+		start, end := ipsearch.IPStrToInt(seg.start), ipsearch.IPStrToInt(seg.end)
+		off := uint32(len(data))
+		data = binary.LittleEndian.AppendUint32(data, start)
+		data = binary.LittleEndian.AppendUint32(data, end)
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(seg.region)))
+		data = binary.LittleEndian.AppendUint32(data, regions[seg.region])
+
+		cell := vectorIndex + int(start>>16)*8
+		if binary.LittleEndian.Uint32(data[cell:]) == 0 {
+			binary.LittleEndian.PutUint32(data[cell:], off)
+		}
+		binary.LittleEndian.PutUint32(data[cell+4:], off+14)
+	}
+	binary.LittleEndian.PutUint32(data[8:], first)
+	binary.LittleEndian.PutUint32(data[12:], uint32(len(data))-14)
+	return data
+}
+
+var xdbSegments = []xdbSegment{
+	{"0.0.0.0", "0.255.255.255", "0|0|0|内网IP|内网IP"},
+	{"1.0.0.0", "1.0.0.255", "澳大利亚|0|0|0|0"},
+	{"1.0.1.0", "1.0.3.255", "中国|0|福建省|福州市|电信"},
+	{"1.0.4.0", "1.0.255.255", "中国|0|福建省|福州市|电信"},
+	{"1.1.0.0", "1.1.0.255", "中国|0|福建省|福州市|电信"},
+	{"114.114.0.0", "114.114.255.255", "中国|0|江苏省|南京市|电信"},
+}
+
+func TestIP2Region(t *testing.T) {
+	data := buildXDB(xdbSegments)
+	ipRanges, err := ipsearch.ReadIP2Region(data)
+	assert.Nil(t, err)
+	// the adjacent segments of the same region are merged
+	assert.Equal(t, 4, len(ipRanges))
+	assert.Equal(t, "1.0.1.0 - 1.1.0.255", ipRanges[2].Range())
+
+	search, err := ipsearch.NewIPSearchFromIP2Region(bytes.NewReader(data))
+	assert.Nil(t, err)
+	ip := search.Search("114.114.114.114")
+	assert.NotNil(t, ip)
+	assert.Equal(t, ipsearch.IP2Region, ip.Type())
+	assert.Equal(t, "中国", ip.Country())
+	assert.Equal(t, "江苏省", ip.Region())
+	assert.Equal(t, "南京市", ip.City())
+	assert.Equal(t, "电信", ip.ISP())
+
+	ip = search.Search("1.0.0.1")
+	assert.Equal(t, "澳大利亚", ip.Country())
+	assert.Equal(t, "", ip.Region())
+	assert.Equal(t, "", ip.ISP())
+	assert.Equal(t, "福州市", search.Search("1.0.200.1").City())
+	assert.Equal(t, "内网IP", search.Search("0.1.2.3").City())
+	assert.Nil(t, search.Search("8.8.8.8"))
+
+	// the metadata survives the snapshot and the mapped index
+	loaded, err := ipsearch.LoadSnapshot(bytes.NewReader(snapshot(t, search)))
+	assert.Nil(t, err)
+	assert.Equal(t, search.Search("114.114.114.114"), loaded.Search("114.114.114.114"))
+	mapped, idx, err := ipsearch.NewIPSearchWithMappedIndex(writeMappedIndex(t, search))
+	assert.Nil(t, err)
+	defer idx.Close()
+	assert.Equal(t, search.Search("114.114.114.114"), mapped.Search("114.114.114.114"))
+}
+
+func TestIP2RegionFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip2region.xdb")
+	assert.Nil(t, os.WriteFile(path, buildXDB(xdbSegments), 0o644))
+	search, err := ipsearch.NewIPSearchWithIP2Region(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "南京市", search.Search("114.114.114.114").City())
+
+	_, err = ipsearch.NewIPSearchWithIP2Region("not-exist-file")
+	assert.NotNil(t, err)
+
+	// the generic constructors load the xdb file, or refuse the lines
+	generic, err := ipsearch.NewIPSearchWithFile(path, ipsearch.IP2Region)
+	assert.Nil(t, err)
+	assert.Equal(t, search.Len(), generic.Len())
+	assert.Equal(t, search.Search("114.114.114.114"), generic.Search("114.114.114.114"))
+
+	lines := []string{string(buildXDB(xdbSegments)[:64])}
+	_, err = ipsearch.NewIPSearchStrict(lines, ipsearch.IP2Region)
+	assert.True(t, errors.Is(err, ipsearch.ErrInvalidRangeType))
+	_, _, err = ipsearch.NewIPSearchWithOptions(lines, ipsearch.IP2Region, nil)
+	assert.True(t, errors.Is(err, ipsearch.ErrInvalidRangeType))
+	assert.Zero(t, ipsearch.NewIPSearch(lines, ipsearch.IP2Region).Len())
+
+	data := buildXDB(xdbSegments)
+	badVersion := append([]byte{3}, data[1:]...)
+	for name, bad := range map[string][]byte{
+		"empty":     {},
+		"version":   badVersion,
+		"truncated": data[:len(data)-7],
+	} {
+		_, err := ipsearch.ReadIP2Region(bad)
+		assert.True(t, errors.Is(err, ipsearch.ErrBadIP2Region), name)
+	}
+}
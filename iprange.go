@@ -1,9 +1,10 @@
-// Package ipsearch provides a simple way to search for IP addresses in a
+// Package ipsearch provides a simple way to search for IPv4 and IPv6 addresses in a
 package ipsearch
 
 import (
-	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	easy "github.com/t-tomalak/logrus-easy-formatter"
@@ -16,14 +17,17 @@ func init() {
 	})
 }
 
-// IPRange represents an IPv4 CIDR range.
+// IPRange represents an IPv4 or IPv6 range.
 type IPRange struct {
 	rangeType RangeType
-	firstSeg  uint8
-	start     uint32
-	end       uint32
+	bucket    uint32
+	start     Uint128
+	end       Uint128
 	cidr      string
 	country   string
+	asn       uint32
+	org       string
+	meta      metadata
 }
 
 // NewIPRange creates a new IPRange.
@@ -33,6 +37,12 @@ func NewIPRange(line string, rangeType RangeType) *IPRange {
 		return NewIPCIDR(line)
 	case Geo:
 		return NewIPGeo(line)
+	case IP2Location:
+		return NewIP2Location(line)
+	case Delegated:
+		return NewDelegated(line)
+	case ASN:
+		return NewIPASN(line)
 	}
 	return nil
 }
@@ -46,32 +56,39 @@ func NewIPRangeSlice(lines []string, rangeType RangeType) []*IPRange {
 	return ipRanges
 }
 
-// NewIPCIDR creates a new IPv4 CIDR range.
+// NewIPCIDR creates a new IPv4 or IPv6 CIDR range.
 func NewIPCIDR(cidr string) *IPRange {
-	start, end := IPCIDRRange(cidr)
+	var start, end Uint128
+	if IsIPv6(cidr) {
+		start, end = IPv6CIDRRange(cidr)
+	} else {
+		s, e := IPCIDRRange(cidr)
+		start, end = ipv4To128(s), ipv4To128(e)
+	}
 	return &IPRange{
 		rangeType: CIDR,
-		firstSeg:  GetIPSegment(cidr, 1),
+		bucket:    bucketOf(start),
 		start:     start,
 		end:       end,
 		cidr:      cidr,
 	}
 }
 
-// NewIPGeo creates a new IPv4 CIDR range with a country code.
+// NewIPGeo creates a new IPv4 or IPv6 range with a country code.
 func NewIPGeo(csv string) *IPRange {
 	fields := strings.Split(csv, ",")
-	firstSegStart := GetIPSegment(fields[0], 1)
-	firstSegEnd := GetIPSegment(fields[1], 1)
-	if firstSegStart != firstSegEnd {
+	for len(fields) < 3 {
+		fields = append(fields, "")
+	}
+	start := parseIP(fields[0])
+	end := parseIP(fields[1])
+	if bucketOf(start) != bucketOf(end) {
 		log.Debugf("First segment of IP range is not the same: %s", csv)
 	}
-	start := IPStrToInt(fields[0])
-	end := IPStrToInt(fields[1])
 	country := fields[2]
 	return &IPRange{
 		rangeType: Geo,
-		firstSeg:  firstSegStart,
+		bucket:    bucketOf(start),
 		start:     start,
 		end:       end,
 		country:   country,
@@ -80,17 +97,19 @@ func NewIPGeo(csv string) *IPRange {
 
 func (ip *IPRange) String() string {
 	switch ip.rangeType {
-	case CIDR:
+	case CIDR, MMDB:
 		return ip.cidr
-	case Geo:
-		return IPIntToStr(ip.start) + "," + IPIntToStr(ip.end) + "," + ip.country
+	case Geo, IP2Location, IP2Region:
+		return ipToStr(ip.start) + "," + ipToStr(ip.end) + "," + ip.country
+	case ASN:
+		return ipToStr(ip.start) + "," + ipToStr(ip.end) + "," + strconv.FormatUint(uint64(ip.asn), 10) + "," + ip.org
 	}
 	return "Bad IPRange Type"
 }
 
 // Range return the range of the IPs in string format.
 func (ip *IPRange) Range() string {
-	return IPIntToStr(ip.start) + " - " + IPIntToStr(ip.end)
+	return ipToStr(ip.start) + " - " + ipToStr(ip.end)
 }
 
 // Country returns the country code of the IP range.
@@ -98,42 +117,141 @@ func (ip *IPRange) Country() string {
 	return ip.country
 }
 
+// ASN returns the autonomous system number of the IP range, or 0.
+func (ip *IPRange) ASN() uint32 {
+	return ip.asn
+}
+
+// Organization returns the autonomous system organization of the IP range.
+func (ip *IPRange) Organization() string {
+	return ip.org
+}
+
+// Region returns the region (the province or the state) of the IP range.
+func (ip *IPRange) Region() string {
+	return ip.meta[metaRegion]
+}
+
+// City returns the city of the IP range.
+func (ip *IPRange) City() string {
+	return ip.meta[metaCity]
+}
+
+// ISP returns the ISP of the IP range.
+func (ip *IPRange) ISP() string {
+	return ip.meta[metaISP]
+}
+
+// Registry returns the regional Internet registry of the IP range, like
+// "apnic" or "ripencc".
+func (ip *IPRange) Registry() string {
+	return ip.meta[metaRegistry]
+}
+
+// Status returns the delegation status of the IP range, like "allocated",
+// "assigned", "available" or "reserved".
+func (ip *IPRange) Status() string {
+	return ip.meta[metaStatus]
+}
+
+// Date returns the allocation date of the IP range, or the zero time if it
+// is unknown.
+func (ip *IPRange) Date() time.Time {
+	date, _ := time.Parse(delegatedDateLayout, ip.meta[metaDate])
+	return date
+}
+
+// Meta returns the metadata value of the key, like the columns of a
+// delimited file mapped by CSVFormat.Metadata, or "" if it is not set.
+func (ip *IPRange) Meta(key string) string {
+	return ip.meta[key]
+}
+
+// Metadata returns a copy of all of the metadata of the IP range, or nil if
+// it has none.
+func (ip *IPRange) Metadata() map[string]string {
+	if len(ip.meta) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(ip.meta))
+	for k, v := range ip.meta {
+		m[k] = v
+	}
+	return m
+}
+
+// WithMetadata returns a copy of the IP range with the metadata of m added,
+// an empty value removes the key. The IP range itself is never mutated, the
+// copy can be added to a Container, or loaded with LoadOptions.Annotate.
+//
+// The metadata survives IPRange.Split, the overlap resolution, the snapshot,
+// the memory-mapped index and the MMDB files written by WriteMMDB.
+func (ip *IPRange) WithMetadata(m map[string]string) *IPRange {
+	withMeta := *ip
+	withMeta.meta = ip.meta.with(m)
+	return &withMeta
+}
+
 // CIDR returns the CIDR of the IP range.
 func (ip *IPRange) CIDR() string {
 	return ip.cidr
 }
 
+// IsIPv6 returns true if the IP range is an IPv6 range.
+func (ip *IPRange) IsIPv6() bool {
+	return !ip.start.IsIPv4()
+}
+
 // Type returns the type of the IP range.
 func (ip *IPRange) Type() RangeType {
 	return ip.rangeType
 }
 
-// Split splits the IPRange into multiple IPRanges if the first segment is different.
+// Split splits the IPRange into multiple IPRanges if the range crosses
+// the buckets. The IPv4 ranges are split by the first octet, the IPv6 ranges
+// crossing the first 16 bits are kept whole in the wide bucket, and the IPv6
+// ranges covering the IPv4 addresses (::ffff:0:0/96) are cut around them, so
+// the IPv4 addresses are found in the IPv4 buckets.
 func (ip *IPRange) Split() []*IPRange {
-	endFirstSeg := GetIPSegment(IPIntToStr(ip.end), 1)
-	if ip.firstSeg == endFirstSeg {
+	if !ip.needSplit() {
 		return []*IPRange{ip}
 	}
 	ipRanges := make([]*IPRange, 0)
 
-	for i := ip.firstSeg; i <= endFirstSeg; i++ {
-		var start, end uint32
-		start = uint32(i) << 24
-		end = start + 0x00FFFFFF
-		if start < ip.start {
-			start = ip.start
+	for start := ip.start; ; {
+		bucket := bucketOf(start)
+		cidr := ip.cidr
+		var end Uint128
+		switch {
+		case start.IsIPv4():
+			_, end = bucketRange(bucket)
+			cidr = bucketCIDR(bucket)
+		case start.Less(ipv4To128(0)):
+			end, _ = ipv4To128(0).subOne()
+		default:
+			end = ip.end
 		}
-		if end > ip.end {
+		if ip.end.Less(end) {
 			end = ip.end
 		}
-		ipRanges = append(ipRanges, &IPRange{
-			rangeType: ip.rangeType,
-			firstSeg:  i,
-			start:     start,
-			end:       end,
-			cidr:      fmt.Sprintf("%d.0.0.0/8", i),
-			country:   ip.country,
-		})
+		// copy the range to keep all of the other fields
+		piece := *ip
+		piece.bucket = rangeBucket(start, end)
+		piece.start = start
+		piece.end = end
+		piece.cidr = cidr
+		ipRanges = append(ipRanges, &piece)
+
+		if end == ip.end {
+			return ipRanges
+		}
+		start, _ = end.addOne()
 	}
-	return ipRanges
+}
+
+// needSplit returns true if the range crosses the buckets, or it is an IPv6
+// range covering the IPv4 addresses.
+func (ip *IPRange) needSplit() bool {
+	v4 := ipv4To128(0)
+	return bucketOf(ip.start) != bucketOf(ip.end) || (ip.start.Less(v4) && !ip.end.Less(v4))
 }
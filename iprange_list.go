@@ -1,15 +1,16 @@
 package ipsearch
 
 import (
+	"net/netip"
 	"sort"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// IPRangeList is a list of IPv4 CIDR ranges.
+// IPRangeList is a list of IPv4 or IPv6 ranges.
 type IPRangeList []*IPRange
 
-// NewIPRangeList creates a new list of IPv4 CIDR ranges.
+// NewIPRangeList creates a new list of IPv4 or IPv6 ranges.
 func NewIPRangeList(lines []string, rangeType RangeType) IPRangeList {
 	list := make(IPRangeList, 0)
 	for _, line := range lines {
@@ -24,12 +25,12 @@ func NewIPRangeList(lines []string, rangeType RangeType) IPRangeList {
 	return list
 }
 
-// Append adds a IPv4 range to the list.
+// Append adds an IP range to the list.
 func (list *IPRangeList) Append(ip *IPRange) {
 	*list = append(*list, ip)
 }
 
-// InsertSorted inserts a IPv4 range to the list, keeping the list sorted.
+// InsertSorted inserts an IP range to the list, keeping the list sorted.
 func (list *IPRangeList) InsertSorted(ip *IPRange) {
 
 	//using the binary search to search the index of list to insert the cidr
@@ -37,7 +38,7 @@ func (list *IPRangeList) InsertSorted(ip *IPRange) {
 	end := len(*list) - 1
 	for start <= end {
 		mid := (start + end) / 2
-		if (*list)[mid].start < ip.start {
+		if (*list)[mid].start.Less(ip.start) {
 			start = mid + 1
 		} else {
 			end = mid - 1
@@ -51,10 +52,15 @@ func (list *IPRangeList) InsertSorted(ip *IPRange) {
 
 }
 
+// Insert inserts an IP range to the list, keeping the list sorted.
+func (list *IPRangeList) Insert(ip *IPRange) {
+	list.InsertSorted(ip)
+}
+
 // Sort sorts the list of IPv4 CIDR ranges.
 func (list *IPRangeList) Sort() {
 	sort.Slice(*list, func(i, j int) bool {
-		return (*list)[i].start < (*list)[j].start
+		return (*list)[i].start.Less((*list)[j].start)
 	})
 }
 
@@ -63,6 +69,15 @@ func (list *IPRangeList) Len() int {
 	return len(*list)
 }
 
+// Walk calls fn for every IP range in the list, it stops when fn returns false.
+func (list *IPRangeList) Walk(fn func(*IPRange) bool) {
+	for _, ip := range *list {
+		if !fn(ip) {
+			return
+		}
+	}
+}
+
 // String returns a string representation of the list of IPv4 CIDR ranges.
 func (list *IPRangeList) String() string {
 	str := ""
@@ -83,22 +98,42 @@ func (list *IPRangeList) Range() string {
 
 // Search search if an IP address is in the list.
 func (list *IPRangeList) Search(ipStr string) *IPRange {
-	ip := IPStrToInt(ipStr)
+	return list.search(parseIP(ipStr))
+}
+
+// SearchAddr search if a netip.Addr is in the list.
+func (list *IPRangeList) SearchAddr(addr netip.Addr) *IPRange {
+	if !addr.IsValid() {
+		return nil
+	}
+	return list.search(addrTo128(addr))
+}
+
+// SearchUint32 search if an integer IPv4 address is in the list.
+func (list *IPRangeList) SearchUint32(ip uint32) *IPRange {
+	return list.search(ipv4To128(ip))
+}
+
+func (list *IPRangeList) search(ip Uint128) *IPRange {
 	// using the binary search to search  the list
 	start := 0
 	end := len(*list) - 1
 	for start <= end {
 		mid := (start + end) / 2
-		if ipInRange(ip, (*list)[mid].start, (*list)[mid].end) {
-			log.Debugf("IP %s is in Range %s", ipStr, (*list)[mid].Range())
+		if ipv6InRange(ip, (*list)[mid].start, (*list)[mid].end) {
+			if log.IsLevelEnabled(log.DebugLevel) {
+				log.Debugf("IP %s is in Range %s", ipToStr(ip), (*list)[mid].Range())
+			}
 			return (*list)[mid]
 		}
-		if ip < (*list)[mid].start {
+		if ip.Less((*list)[mid].start) {
 			end = mid - 1
 		} else {
 			start = mid + 1
 		}
 	}
-	log.Debugf("IP %s is not in any following Ranges. \n%s", ipStr, list)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("IP %s is not in any following Ranges. \n%s", ipToStr(ip), list)
+	}
 	return nil
 }
@@ -1,7 +1,14 @@
 package ipsearch
 
-// IPRangeMapList is a map of lists of IPv4 CIDR ranges.
-type IPRangeMapList map[uint8]*IPRangeList
+import (
+	"net/netip"
+	"sort"
+)
+
+// IPRangeMapList is a map of lists of IP ranges. The key is the bucket of
+// the ranges: the first octet for IPv4 and the first 16 bits for IPv6, the
+// IPv6 ranges crossing the 16 bits are in a wide bucket, see IPRange.Split.
+type IPRangeMapList map[uint32]*IPRangeList
 
 // NewIPRangeMapList creates a new map of lists of IPv4 CIDR ranges.
 func NewIPRangeMapList() IPRangeMapList {
@@ -12,6 +19,9 @@ func NewIPRangeMapList() IPRangeMapList {
 // AppendBatch adds a list of IPv4 CIDR ranges to the map of lists of IPv4 CIDR ranges.
 func (m IPRangeMapList) AppendBatch(ipRanges []*IPRange) {
 	for _, ip := range ipRanges {
+		if ip == nil {
+			continue
+		}
 		ips := ip.Split()
 		for _, ip := range ips {
 			m.Append(ip)
@@ -21,7 +31,7 @@ func (m IPRangeMapList) AppendBatch(ipRanges []*IPRange) {
 
 // Append adds an IPv4 CIDR range to the map of lists of IPv4 CIDR ranges.
 func (m IPRangeMapList) Append(ipRange *IPRange) {
-	ip1 := ipRange.firstSeg
+	ip1 := ipRange.bucket
 	if _, ok := m[ip1]; !ok {
 		m[ip1] = &IPRangeList{}
 	}
@@ -37,13 +47,20 @@ func (m IPRangeMapList) Sort() {
 
 // InsertSorted inserts a IPv4 CIDR range to the map of lists of IPv4 CIDR ranges, keeping the lists sorted.
 func (m IPRangeMapList) InsertSorted(ipRange *IPRange) {
-	ip1 := ipRange.firstSeg
+	ip1 := ipRange.bucket
 	if _, ok := m[ip1]; !ok {
 		m[ip1] = &IPRangeList{}
 	}
 	(*m[ip1]).InsertSorted(ipRange)
 }
 
+// Insert splits an IP range and inserts the pieces to the map of lists, keeping the lists sorted.
+func (m IPRangeMapList) Insert(ipRange *IPRange) {
+	for _, ip := range ipRange.Split() {
+		m.InsertSorted(ip)
+	}
+}
+
 // InsertSortedCIDRs inserts a list of IPv4 CIDR ranges to the map of lists of IPv4 CIDR ranges, keeping the lists sorted.
 func (m IPRangeMapList) InsertSortedCIDRs(ipRanges []*IPRange) {
 	for _, ip := range ipRanges {
@@ -53,9 +70,58 @@ func (m IPRangeMapList) InsertSortedCIDRs(ipRanges []*IPRange) {
 
 // Search search if an IP address is in the map of lists.
 func (m IPRangeMapList) Search(ipStr string) *IPRange {
-	ip1 := GetIPSegment(ipStr, 1)
-	if _, ok := m[ip1]; !ok {
+	return m.search(parseIP(ipStr))
+}
+
+// SearchAddr search if a netip.Addr is in the map of lists.
+func (m IPRangeMapList) SearchAddr(addr netip.Addr) *IPRange {
+	if !addr.IsValid() {
 		return nil
 	}
-	return (*m[ip1]).Search(ipStr)
+	return m.search(addrTo128(addr))
+}
+
+// SearchUint32 search if an integer IPv4 address is in the map of lists.
+func (m IPRangeMapList) SearchUint32(ip uint32) *IPRange {
+	return m.search(ipv4To128(ip))
+}
+
+func (m IPRangeMapList) search(ip Uint128) *IPRange {
+	if list, ok := m[bucketOf(ip)]; ok {
+		if found := list.search(ip); found != nil {
+			return found
+		}
+	}
+	if list, ok := m[wideBucket]; ok && !ip.IsIPv4() {
+		return list.search(ip)
+	}
+	return nil
+}
+
+// Len returns the number of the IP ranges in the map of lists, the split ranges are counted by pieces.
+func (m IPRangeMapList) Len() int {
+	n := 0
+	for _, list := range m {
+		n += list.Len()
+	}
+	return n
+}
+
+// Walk calls fn for every IP range in the map of lists in the order of the
+// addresses, IPv4 first and the wide IPv6 ranges last, it stops when fn
+// returns false.
+func (m IPRangeMapList) Walk(fn func(*IPRange) bool) {
+	buckets := make([]uint32, 0, len(m))
+	for bucket := range m {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	for _, bucket := range buckets {
+		for _, ip := range *m[bucket] {
+			if !fn(ip) {
+				return
+			}
+		}
+	}
 }
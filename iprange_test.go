@@ -86,3 +86,92 @@ func TestIPRangeSplit(t *testing.T) {
 	assert.Equal(t, 1, len(ips))
 	assert.Equal(t, "1.1.1.2 - 1.1.1.4", ips[0].Range())
 }
+
+var cidrs6 = []string{
+	"2001:db8::/32",
+	"2400:cb00::/32",
+	"2a00:1450:4000::/37",
+	"240e::/20",
+}
+
+var geo6 = []string{
+	"2001:200::,2001:200:ffff:ffff:ffff:ffff:ffff:ffff,JP",
+	"2400:cb00::,2400:cb00:ffff:ffff:ffff:ffff:ffff:ffff,US",
+	"240e::,240e:fff:ffff:ffff:ffff:ffff:ffff:ffff,CN",
+	"2a00:1450::,2a00:1450:ffff:ffff:ffff:ffff:ffff:ffff,IE",
+}
+
+func TestIPv6Range(t *testing.T) {
+	cidr_ips := ipsearch.NewIPRangeSlice(cidrs6, ipsearch.CIDR)
+	for i, cidr_ip := range cidr_ips {
+		assert.True(t, cidr_ip.IsIPv6())
+		assert.Equal(t, cidrs6[i], cidr_ip.String())
+	}
+	assert.Equal(t, "240e:: - 240e:fff:ffff:ffff:ffff:ffff:ffff:ffff", cidr_ips[3].Range())
+
+	geo_ips := ipsearch.NewIPRangeSlice(geo6, ipsearch.Geo)
+	for i, geo_ip := range geo_ips {
+		assert.True(t, geo_ip.IsIPv6())
+		assert.Equal(t, geo6[i], geo_ip.String())
+		assert.NotEmpty(t, geo_ip.Country())
+	}
+
+	ip := ipsearch.NewIPCIDR("1.0.1.0/24")
+	assert.False(t, ip.IsIPv6())
+
+	// a malformed line must not panic
+	ip = ipsearch.NewIPGeo("1.0.1.0")
+	assert.Empty(t, ip.Country())
+}
+
+func TestIPv6RangeSplit(t *testing.T) {
+	ip := ipsearch.NewIPRange("2001:db8::/31", ipsearch.CIDR)
+	ips := ip.Split()
+	assert.Equal(t, 1, len(ips))
+
+	// the IPv6 ranges crossing the buckets are kept whole
+	ip = ipsearch.NewIPRange("2001:db8::,2003:ffff::,US", ipsearch.Geo)
+	ips = ip.Split()
+	assert.Equal(t, 1, len(ips))
+	assert.Equal(t, "2001:db8:: - 2003:ffff::", ips[0].Range())
+
+	ip = ipsearch.NewIPRange("2000::/3", ipsearch.CIDR)
+	ips = ip.Split()
+	assert.Equal(t, 1, len(ips))
+	assert.Equal(t, "2000::/3", ips[0].CIDR())
+
+	// the IPv4 addresses are cut out, and split by the first octet
+	ip = ipsearch.NewIPRange("::/0", ipsearch.CIDR)
+	ips = ip.Split()
+	assert.Equal(t, 258, len(ips))
+	assert.Equal(t, ":: - ::fffe:ffff:ffff", ips[0].Range())
+	assert.Equal(t, "0.0.0.0 - 0.255.255.255", ips[1].Range())
+	assert.Equal(t, "255.0.0.0 - 255.255.255.255", ips[256].Range())
+	assert.Equal(t, "::1:0:0:0 - ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", ips[257].Range())
+	assert.Equal(t, "::/0", ips[257].CIDR())
+}
+
+func TestIPRangeMetadata(t *testing.T) {
+	ip := ipsearch.NewIPRange("3.0.0.0,4.255.255.255,US", ipsearch.Geo)
+	assert.Nil(t, ip.Metadata())
+
+	tagged := ip.WithMetadata(map[string]string{"dc": "us-east-1", "risk": "0.3"})
+	assert.Equal(t, "", ip.Meta("dc"))
+	assert.Equal(t, "us-east-1", tagged.Meta("dc"))
+	assert.Equal(t, "US", tagged.Country())
+	assert.Equal(t, ip.Range(), tagged.Range())
+
+	// the metadata is copied out
+	m := tagged.Metadata()
+	m["dc"] = "changed"
+	assert.Equal(t, "us-east-1", tagged.Meta("dc"))
+
+	// the empty values remove the keys
+	updated := tagged.WithMetadata(map[string]string{"risk": "", "owner": "ops"})
+	assert.Equal(t, map[string]string{"dc": "us-east-1", "owner": "ops"}, updated.Metadata())
+	assert.Nil(t, updated.WithMetadata(map[string]string{"dc": "", "owner": ""}).Metadata())
+
+	for _, piece := range tagged.Split() {
+		assert.Equal(t, tagged.Metadata(), piece.Metadata())
+	}
+}
@@ -0,0 +1,210 @@
+package ipsearch
+
+import (
+	"math/bits"
+	"net/netip"
+)
+
+// IPRangeTrie is a path-compressed binary trie (radix tree) of IP ranges.
+//
+// The ranges are indexed by their CIDR prefixes, a range which is not CIDR
+// aligned (e.g. a Geo range) is decomposed into the minimal set of CIDRs.
+// The search uses the longest-prefix-match semantics, so the nested ranges,
+// like a /16 allow list with /24 exceptions, resolve to the most specific one.
+type IPRangeTrie struct {
+	root *trieNode
+	// prefixes counts the prefixes of every range in the trie, a range
+	// is dropped when all of its prefixes are replaced.
+	prefixes map[*IPRange]int
+}
+
+type trieNode struct {
+	prefix Uint128 // the masked prefix
+	bits   int     // the prefix length, 0 - 128
+	value  *IPRange
+	child  [2]*trieNode
+}
+
+// NewIPRangeTrie creates a new empty trie of IP ranges.
+func NewIPRangeTrie() *IPRangeTrie {
+	return &IPRangeTrie{prefixes: make(map[*IPRange]int)}
+}
+
+// Insert adds an IP range to the trie, the prefixes of the range replace
+// the same prefixes of the existing ranges, and a range with all of its
+// prefixes replaced is not counted any more.
+func (t *IPRangeTrie) Insert(ipRange *IPRange) {
+	if t.prefixes == nil {
+		t.prefixes = make(map[*IPRange]int)
+	}
+	for _, p := range rangeToPrefixes(ipRange.start, ipRange.end) {
+		t.prefixes[ipRange]++
+		if old := t.insert(p.ip, p.bits, ipRange); old != nil {
+			if t.prefixes[old]--; t.prefixes[old] == 0 {
+				delete(t.prefixes, old)
+			}
+		}
+	}
+}
+
+// InsertBatch adds a list of IP ranges to the trie.
+func (t *IPRangeTrie) InsertBatch(ipRanges []*IPRange) {
+	for _, ip := range ipRanges {
+		t.Insert(ip)
+	}
+}
+
+// Len returns the number of the IP ranges inserted.
+func (t *IPRangeTrie) Len() int {
+	return len(t.prefixes)
+}
+
+// Walk calls fn for every IP range in the trie in the order of the
+// addresses, it stops when fn returns false.
+func (t *IPRangeTrie) Walk(fn func(*IPRange) bool) {
+	seen := make(map[*IPRange]struct{})
+	t.root.walk(func(ip *IPRange) bool {
+		if _, ok := seen[ip]; ok {
+			return true
+		}
+		seen[ip] = struct{}{}
+		return fn(ip)
+	})
+}
+
+func (n *trieNode) walk(fn func(*IPRange) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.value != nil && !fn(n.value) {
+		return false
+	}
+	return n.child[0].walk(fn) && n.child[1].walk(fn)
+}
+
+// Search search the most specific IP range containing an IP address.
+func (t *IPRangeTrie) Search(ipStr string) *IPRange {
+	return t.search(parseIP(ipStr))
+}
+
+// SearchAddr search the most specific IP range containing a netip.Addr.
+func (t *IPRangeTrie) SearchAddr(addr netip.Addr) *IPRange {
+	if !addr.IsValid() {
+		return nil
+	}
+	return t.search(addrTo128(addr))
+}
+
+// SearchUint32 search the most specific IP range containing an integer IPv4 address.
+func (t *IPRangeTrie) SearchUint32(ip uint32) *IPRange {
+	return t.search(ipv4To128(ip))
+}
+
+func (t *IPRangeTrie) search(ip Uint128) *IPRange {
+	var best *IPRange
+	for n := t.root; n != nil; n = n.child[ip.bit(n.bits)] {
+		if commonPrefixLen(n.prefix, ip) < n.bits {
+			break
+		}
+		if n.value != nil {
+			best = n.value
+		}
+		if n.bits == 128 {
+			break
+		}
+	}
+	return best
+}
+
+// insert sets the value of a prefix, it returns the replaced value of the
+// prefix, or nil.
+func (t *IPRangeTrie) insert(ip Uint128, prefixLen int, value *IPRange) *IPRange {
+	link := &t.root
+	for {
+		n := *link
+		if n == nil {
+			*link = &trieNode{prefix: ip, bits: prefixLen, value: value}
+			return nil
+		}
+
+		common := commonPrefixLen(n.prefix, ip)
+		if common > n.bits {
+			common = n.bits
+		}
+		if common > prefixLen {
+			common = prefixLen
+		}
+
+		if common == n.bits {
+			if n.bits == prefixLen {
+				old := n.value
+				n.value = value
+				return old
+			}
+			link = &n.child[ip.bit(n.bits)]
+			continue
+		}
+
+		// the new prefix diverges in the middle of the node, split it
+		split := &trieNode{prefix: ip.andNot(hostMask(common)), bits: common}
+		split.child[n.prefix.bit(common)] = n
+		if common == prefixLen {
+			split.value = value
+		} else {
+			split.child[ip.bit(common)] = &trieNode{prefix: ip, bits: prefixLen, value: value}
+		}
+		*link = split
+		return nil
+	}
+}
+
+// bit returns the i-th bit of u, counting from the most significant bit.
+func (u Uint128) bit(i int) int {
+	if i < 64 {
+		return int(u.Hi>>(63-i)) & 1
+	}
+	return int(u.Lo>>(127-i)) & 1
+}
+
+// commonPrefixLen returns the length of the common prefix of a and b.
+func commonPrefixLen(a, b Uint128) int {
+	if x := a.Hi ^ b.Hi; x != 0 {
+		return bits.LeadingZeros64(x)
+	}
+	return 64 + bits.LeadingZeros64(a.Lo^b.Lo)
+}
+
+// trailingZeros returns the number of the trailing zero bits of u.
+func (u Uint128) trailingZeros() int {
+	if u.Lo != 0 {
+		return bits.TrailingZeros64(u.Lo)
+	}
+	return 64 + bits.TrailingZeros64(u.Hi)
+}
+
+// prefix is a CIDR prefix in the 128-bit address space.
+type prefix struct {
+	ip   Uint128
+	bits int
+}
+
+// rangeToPrefixes decomposes a range into the minimal list of CIDR prefixes.
+func rangeToPrefixes(start, end Uint128) []prefix {
+	var prefixes []prefix
+	if end.Less(start) {
+		return prefixes
+	}
+	for {
+		n := 128 - start.trailingZeros()
+		for n < 128 && end.Less(start.or(hostMask(n))) {
+			n++
+		}
+		prefixes = append(prefixes, prefix{start, n})
+
+		last := start.or(hostMask(n))
+		if last == end {
+			return prefixes
+		}
+		start, _ = last.addOne()
+	}
+}
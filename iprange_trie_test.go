@@ -0,0 +1,151 @@
+package ipsearch_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+func TestIPRangeTrie(t *testing.T) {
+	trie := ipsearch.NewIPRangeTrie()
+	trie.InsertBatch(ipsearch.NewIPRangeSlice(append(cidrs, cidrs6...), ipsearch.CIDR))
+	assert.Equal(t, len(cidrs)+len(cidrs6), trie.Len())
+
+	for _, data := range testCIDRDataList {
+		ip := trie.Search(data.ip)
+		assert.Equal(t, ip != nil, data.find, data.ip)
+		if ip != nil {
+			assert.Equal(t, ip.String(), data.cidr)
+		}
+	}
+	ip := trie.Search("2a00:1450:4001:82b::200e")
+	assert.NotNil(t, ip)
+	assert.Equal(t, "2a00:1450:4000::/37", ip.CIDR())
+	assert.Nil(t, trie.Search("2a00:1450:4800::1"))
+
+	// longest prefix match
+	trie = ipsearch.NewIPRangeTrie()
+	trie.InsertBatch(ipsearch.NewIPRangeSlice(overlapCIDRs, ipsearch.CIDR))
+	all, err := ipsearch.ParseCIDR("0.0.0.0/0")
+	assert.Nil(t, err)
+	trie.Insert(all)
+	for ipStr, cidr := range map[string]string{
+		"10.0.0.1":     "10.0.0.0/16",
+		"10.0.1.1":     "10.0.1.0/24",
+		"10.0.255.200": "10.0.255.128/25",
+		"10.1.0.1":     "0.0.0.0/0",
+		"192.168.0.1":  "192.168.0.0/24",
+		"8.8.8.8":      "0.0.0.0/0",
+	} {
+		ip := trie.Search(ipStr)
+		assert.NotNil(t, ip, ipStr)
+		assert.Equal(t, cidr, ip.CIDR(), ipStr)
+	}
+	assert.Nil(t, trie.Search("2001:db8::1"))
+
+	// the ranges which are not CIDR aligned
+	trie = ipsearch.NewIPRangeTrie()
+	trie.InsertBatch(ipsearch.NewIPRangeSlice(append(geo, geo6...), ipsearch.Geo))
+	for _, data := range testGeoDataList {
+		ip := trie.Search(data.ip)
+		assert.Equal(t, ip != nil, data.find, data.ip)
+		if ip != nil {
+			assert.Equal(t, ip.Country(), data.geo)
+		}
+	}
+	ip = trie.Search("240e:3b7::1")
+	assert.NotNil(t, ip)
+	assert.Equal(t, "CN", ip.Country())
+	ip = trie.Search("2.56.179.255")
+	assert.NotNil(t, ip)
+	assert.Equal(t, "CY", ip.Country())
+}
+
+func TestIPRangeTrieReplace(t *testing.T) {
+	trie := ipsearch.NewIPRangeTrie()
+	trie.Insert(ipsearch.NewIPRange("10.0.0.0/8", ipsearch.CIDR))
+	trie.Insert(ipsearch.NewIPRange("10.0.0.0,10.255.255.255,US", ipsearch.Geo))
+	assert.Equal(t, 1, trie.Len())
+	assert.Equal(t, "US", trie.Search("10.1.1.1").Country())
+
+	n := 0
+	trie.Walk(func(*ipsearch.IPRange) bool {
+		n++
+		return true
+	})
+	assert.Equal(t, trie.Len(), n)
+
+	trie.Insert(ipsearch.NewIPRange("10.0.0.0/16", ipsearch.CIDR))
+	assert.Equal(t, 2, trie.Len())
+
+	// a range replacing all of the prefixes of another one drops it
+	trie = ipsearch.NewIPRangeTrie()
+	trie.Insert(ipsearch.NewIPRange("10.0.0.0/8", ipsearch.CIDR))
+	trie.Insert(ipsearch.NewIPRange("9.0.0.0,10.255.255.255,US", ipsearch.Geo))
+	assert.Equal(t, 1, trie.Len())
+	assert.Equal(t, "US", trie.Search("10.1.1.1").Country())
+	n = 0
+	trie.Walk(func(*ipsearch.IPRange) bool {
+		n++
+		return true
+	})
+	assert.Equal(t, trie.Len(), n)
+
+	// a range losing some of its prefixes is still counted
+	trie.Insert(ipsearch.NewIPRange("9.0.0.0/8", ipsearch.CIDR))
+	assert.Equal(t, 2, trie.Len())
+	assert.Equal(t, "US", trie.Search("10.1.1.1").Country())
+}
+
+func TestTrieBackend(t *testing.T) {
+	opts := &ipsearch.LoadOptions{Backend: ipsearch.TrieBackend}
+	search, _, err := ipsearch.NewIPSearchWithOptions(overlapCIDRs, ipsearch.CIDR, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.1.0/24", search.Search("10.0.1.1").CIDR())
+	assert.Equal(t, "10.0.255.128/25", search.Search("10.0.255.129").CIDR())
+	assert.Equal(t, "10.0.0.0/16", search.Search("10.0.3.1").CIDR())
+
+	search, _, err = ipsearch.NewIPSearchWithFileOptions(IPv4CIDRFile, ipsearch.CIDR, opts)
+	assert.Nil(t, err)
+	testCIDRSearch(t, search)
+
+	search, _, err = ipsearch.NewIPSearchWithFileOptions(IPv4GeoFile, ipsearch.Geo, opts)
+	assert.Nil(t, err)
+	testGeoSearch(t, search)
+}
+
+var benchIPs = []string{
+	"1.0.1.24", "114.114.114.114", "8.8.8.8", "223.5.5.5",
+	"185.226.6.1", "27.100.25.1", "31.25.64.1", "192.168.1.1",
+}
+
+func benchmarkSearch(b *testing.B, path string, rangeType ipsearch.RangeType, backend ipsearch.Backend) {
+	opts := &ipsearch.LoadOptions{Backend: backend}
+	search, _, err := ipsearch.NewIPSearchWithFileOptions(path, rangeType, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		search.Search(benchIPs[i%len(benchIPs)])
+	}
+}
+
+func BenchmarkMapListCIDR(b *testing.B) {
+	benchmarkSearch(b, IPv4CIDRFile, ipsearch.CIDR, ipsearch.MapListBackend)
+}
+
+func BenchmarkTrieCIDR(b *testing.B) {
+	benchmarkSearch(b, IPv4CIDRFile, ipsearch.CIDR, ipsearch.TrieBackend)
+}
+
+func BenchmarkMapListGeo(b *testing.B) {
+	benchmarkSearch(b, IPv4GeoFile, ipsearch.Geo, ipsearch.MapListBackend)
+}
+
+func BenchmarkTrieGeo(b *testing.B) {
+	benchmarkSearch(b, IPv4GeoFile, ipsearch.Geo, ipsearch.TrieBackend)
+}
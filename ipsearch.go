@@ -1,40 +1,247 @@
 package ipsearch
 
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
 // RangeType is the type of file
 type RangeType int
 
 const (
-	// CIDR is a file that contains CIDR ranges
+	// CIDR is a file that contains IPv4 or IPv6 CIDR ranges
 	CIDR RangeType = iota
-	// Geo is a file that contains GeoIP ranges and the country code as the CSV format
+	// Geo is a file that contains IPv4 or IPv6 GeoIP ranges and the country code as the CSV format
 	Geo
+	// MMDB is a MaxMind DB file, the networks are loaded as the CIDR ranges
+	// with the country code and the ASN, see ReadMMDB.
+	MMDB
+	// IP2Location is an IP2Location LITE CSV file, the start and the end are
+	// the decimal integers, and the fields are quoted.
+	IP2Location
+	// IP2Region is an ip2region xdb file, the ranges have the country, the
+	// region (province), the city and the ISP, see ReadIP2Region.
+	IP2Region
+	// Delegated is an RIR delegated stats file (delegated-*-latest or
+	// delegated-*-extended-latest), the ranges are loaded as the Geo ranges,
+	// see ParseDelegatedLine.
+	Delegated
+	// ASN is a file that contains IPv4 or IPv6 ranges with the AS number and
+	// the AS organization as the CSV format, like the asn-ipv4.csv of
+	// ip-location-db, see ParseASNLine.
+	ASN
+)
+
+// Backend is the index structure behind an IPSearch.
+type Backend int
+
+const (
+	// MapListBackend indexes the ranges with an IPRangeMapList.
+	MapListBackend Backend = iota
+	// TrieBackend indexes the ranges with an IPRangeTrie, the search uses
+	// the longest-prefix-match semantics.
+	TrieBackend
+	// ListBackend indexes the ranges with a single sorted IPRangeList.
+	ListBackend
 )
 
-// IPSearch is a struct that contains a map of IP ranges.
+// ErrReloadNotSupported is returned when reloading an IPSearch created
+// with NewIPSearchWithContainer, which doesn't know how to load the lines.
+var ErrReloadNotSupported = errors.New("reload is not supported")
+
+// IPSearch is a struct that contains an index of IP ranges.
+//
+// It is safe for concurrent use, the index is never mutated after it is
+// built, a reload builds a new index and swaps it atomically.
 type IPSearch struct {
-	container IPRangeMapList
+	index atomic.Pointer[searchIndex]
+	load  loadFunc
+	// updateLoad loads the lines of an update, it skips the malformed lines
+	// of a lenient IPSearch, so the Updater doesn't load an error page as
+	// the zero value ranges. It is load if nil.
+	updateLoad loadFunc
+	reloadMu   sync.Mutex
 }
 
-// NewIPSearch creates a new IPSearch struct.
+type searchIndex struct {
+	container Container
+}
+
+// loadFunc builds a new container from the lines of an IP range list.
+type loadFunc func(lines []string) (Container, *LoadReport, error)
+
+// NewIPSearch creates a new IPSearch struct. The range type of a binary
+// file, like MMDB or IP2Region, is logged as an error and the IPSearch is
+// empty, use the constructors returning the errors to check it.
 func NewIPSearch(lines []string, rangeType RangeType) *IPSearch {
+	c, _, err := lenientLoader(rangeType)(lines)
+	if err != nil {
+		log.Errorf("Failed to load the IP ranges: %v", err)
+		c = NewIPRangeMapList()
+	}
+	return newLenientIPSearch(c, rangeType)
+}
+
+func newLenientIPSearch(c Container, rangeType RangeType) *IPSearch {
+	s := newIPSearch(c, lenientLoader(rangeType))
+	s.updateLoad = func(lines []string) (Container, *LoadReport, error) {
+		ipRanges, report, err := LoadIPRanges(lines, rangeType, &LoadOptions{OnError: SkipAndLog})
+		if err != nil {
+			return nil, report, err
+		}
+		return newContainer(ipRanges, MapListBackend), report, nil
+	}
+	return s
+}
+
+func lenientLoader(rangeType RangeType) loadFunc {
+	return func(lines []string) (Container, *LoadReport, error) {
+		if err := checkLineRangeType(rangeType); err != nil {
+			return nil, nil, err
+		}
+		return newContainer(NewIPRangeSlice(lines, rangeType), MapListBackend), nil, nil
+	}
+}
+
+// NewIPSearchFromReader creates a new IPSearch struct from a reader, the
+// lines are parsed and inserted one by one, without holding all of the
+// lines or ranges in the intermediate slices. The gzip, zstd and xz
+// compressed input is decompressed on the fly. An MMDB file is loaded by
+// NewIPSearchFromMMDB, and an ip2region xdb file by NewIPSearchFromIP2Region.
+func NewIPSearchFromReader(r io.Reader, rangeType RangeType) (*IPSearch, error) {
+	switch rangeType {
+	case MMDB:
+		return NewIPSearchFromMMDB(r)
+	case IP2Region:
+		return NewIPSearchFromIP2Region(r)
+	}
+	if err := checkLineRangeType(rangeType); err != nil {
+		return nil, err
+	}
+
+	rc, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	m := NewIPRangeMapList()
+	strs := make(map[string]string)
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		ipRange := NewIPRange(scanner.Text(), rangeType)
+		if ipRange == nil {
+			continue
+		}
+		// the country and the organization are the substrings of the line,
+		// intern them to release the line
+		ipRange.country = internString(strs, ipRange.country)
+		ipRange.org = internString(strs, ipRange.org)
+		for _, ip := range ipRange.Split() {
+			m.Append(ip)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	m.Sort()
+	return newLenientIPSearch(m, rangeType), nil
+}
+
+// NewIPSearchStrict creates a new IPSearch struct, it refuses to load
+// malformed lines and returns a ParseErrors listing all of them.
+func NewIPSearchStrict(lines []string, rangeType RangeType) (*IPSearch, error) {
+	load := func(lines []string) (Container, *LoadReport, error) {
+		ipRanges, err := ParseIPRanges(lines, rangeType)
+		if err != nil {
+			return nil, nil, err
+		}
+		return newContainer(ipRanges, MapListBackend), nil, nil
+	}
+	c, _, err := load(lines)
+	if err != nil {
+		return nil, err
+	}
+	return newIPSearch(c, load), nil
+}
+
+// internString returns the shared clone of s in strs.
+func internString(strs map[string]string, s string) string {
+	if s == "" {
+		return s
+	}
+	interned, ok := strs[s]
+	if !ok {
+		interned = strings.Clone(s)
+		strs[interned] = interned
+	}
+	return interned
+}
+
+func newIPSearch(c Container, load loadFunc) *IPSearch {
+	s := &IPSearch{load: load}
+	s.index.Store(&searchIndex{container: c})
+	return s
+}
+
+func newContainer(ipRanges []*IPRange, backend Backend) Container {
+	switch backend {
+	case TrieBackend:
+		t := NewIPRangeTrie()
+		t.InsertBatch(ipRanges)
+		return t
+	case ListBackend:
+		list := IPRangeList(ipRanges)
+		list.Sort()
+		return &list
+	}
 	m := NewIPRangeMapList()
-	ipRanges := NewIPRangeSlice(lines, rangeType)
 	m.AppendBatch(ipRanges)
 	m.Sort()
-	return &IPSearch{container: m}
+	return m
+}
+
+// NewIPSearchWithContainer creates a new IPSearch struct with a container,
+// the container is used as it is, and the IPSearch cannot be reloaded.
+func NewIPSearchWithContainer(c Container) *IPSearch {
+	return newIPSearch(c, nil)
 }
 
-// NewIPSearchWithFile creates a new IPSearch struct from a file.
+// NewIPSearchWithFile creates a new IPSearch struct from a file, see
+// NewIPSearchFromReader.
 func NewIPSearchWithFile(path string, rangeType RangeType) (*IPSearch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return NewIPSearchFromReader(file, rangeType)
+}
+
+// NewIPSearchWithFileStrict creates a new IPSearch struct from a file, see NewIPSearchStrict.
+func NewIPSearchWithFileStrict(path string, rangeType RangeType) (*IPSearch, error) {
 	lines, err := ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return NewIPSearch(lines, rangeType), nil
+	return NewIPSearchStrict(lines, rangeType)
 }
 
 // NewIPSearchWithFileFromURL creates a new IPSearch struct from a URL.
 func NewIPSearchWithFileFromURL(url string, fileType RangeType) (*IPSearch, error) {
+	if err := checkLineRangeType(fileType); err != nil {
+		return nil, err
+	}
 	lines, err := ReadFileFromURL(url)
 	if err != nil {
 		return nil, err
@@ -42,7 +249,171 @@ func NewIPSearchWithFileFromURL(url string, fileType RangeType) (*IPSearch, erro
 	return NewIPSearch(lines, fileType), nil
 }
 
-// Search search if an IP address is in the map of lists of IPv4 ranges.
+// NewIPSearchFromURLContext creates a new IPSearch struct from a URL with the
+// context and the HTTP options, see ReadFileFromURLWithOptions.
+func NewIPSearchFromURLContext(ctx context.Context, url string, rangeType RangeType, opts *HTTPOptions) (*IPSearch, error) {
+	if err := checkLineRangeType(rangeType); err != nil {
+		return nil, err
+	}
+	lines, err := ReadFileFromURLWithOptions(ctx, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewIPSearch(lines, rangeType), nil
+}
+
+// NewIPSearchWithFileFromURLStrict creates a new IPSearch struct from a URL, see NewIPSearchStrict.
+func NewIPSearchWithFileFromURLStrict(url string, rangeType RangeType) (*IPSearch, error) {
+	lines, err := ReadFileFromURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return NewIPSearchStrict(lines, rangeType)
+}
+
+// NewIPSearchWithOptions creates a new IPSearch struct with the load options,
+// and returns a report of the loading, see LoadIPRanges.
+func NewIPSearchWithOptions(lines []string, rangeType RangeType, opts *LoadOptions) (*IPSearch, *LoadReport, error) {
+	if opts == nil {
+		opts = &LoadOptions{}
+	}
+	load := func(lines []string) (Container, *LoadReport, error) {
+		ipRanges, report, err := LoadIPRanges(lines, rangeType, opts)
+		if err != nil {
+			return nil, report, err
+		}
+		if opts.NewContainer != nil {
+			c := opts.NewContainer()
+			for _, ipRange := range ipRanges {
+				c.Insert(ipRange)
+			}
+			return c, report, nil
+		}
+		return newContainer(ipRanges, opts.Backend), report, nil
+	}
+	c, report, err := load(lines)
+	if err != nil {
+		return nil, report, err
+	}
+	return newIPSearch(c, load), report, nil
+}
+
+// NewIPSearchWithFileOptions creates a new IPSearch struct from a file with the load options.
+func NewIPSearchWithFileOptions(path string, rangeType RangeType, opts *LoadOptions) (*IPSearch, *LoadReport, error) {
+	lines, err := ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewIPSearchWithOptions(lines, rangeType, opts)
+}
+
+// NewIPSearchWithFileFromURLOptions creates a new IPSearch struct from a URL with the load options.
+func NewIPSearchWithFileFromURLOptions(url string, rangeType RangeType, opts *LoadOptions) (*IPSearch, *LoadReport, error) {
+	lines, err := ReadFileFromURL(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewIPSearchWithOptions(lines, rangeType, opts)
+}
+
+// Reload rebuilds the index from the lines, with the same range type and
+// options the IPSearch was created with, and swaps it atomically. The
+// concurrent searches use the old index until the swap, and the old index is
+// kept if the loading fails. The report is nil unless the IPSearch was
+// created with the load options.
+func (s *IPSearch) Reload(lines []string) (*LoadReport, error) {
+	return s.reload(lines, s.load, nil)
+}
+
+// ValidateFunc checks a newly loaded IPSearch before it is swapped in, the
+// candidate can be searched, but cannot be reloaded.
+type ValidateFunc func(candidate *IPSearch, report *LoadReport) error
+
+// reload rebuilds the index with the load function, and swaps it if the
+// validation passes.
+func (s *IPSearch) reload(lines []string, load loadFunc, validate ValidateFunc) (*LoadReport, error) {
+	if load == nil {
+		return nil, ErrReloadNotSupported
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	c, report, err := load(lines)
+	if err != nil {
+		return report, err
+	}
+	if validate != nil {
+		if err := validate(newIPSearch(c, nil), report); err != nil {
+			return report, err
+		}
+	}
+	s.index.Store(&searchIndex{container: c})
+	return report, nil
+}
+
+// ReloadFromFile rebuilds the index from a file, see Reload.
+func (s *IPSearch) ReloadFromFile(path string) (*LoadReport, error) {
+	lines, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.Reload(lines)
+}
+
+// ReloadFromURL rebuilds the index from a URL, see Reload.
+func (s *IPSearch) ReloadFromURL(url string) (*LoadReport, error) {
+	lines, err := ReadFileFromURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return s.Reload(lines)
+}
+
+// ReloadFromURLContext rebuilds the index from a URL with the context and the
+// HTTP options, see Reload.
+func (s *IPSearch) ReloadFromURLContext(ctx context.Context, url string, opts *HTTPOptions) (*LoadReport, error) {
+	lines, err := ReadFileFromURLWithOptions(ctx, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.Reload(lines)
+}
+
+// Search search if an IPv4 or IPv6 address is in the map of lists of IP ranges.
 func (s *IPSearch) Search(ip string) *IPRange {
-	return s.container.Search(ip)
+	return s.Container().Search(ip)
+}
+
+// SearchAddr search if a netip.Addr is in the IP ranges, it doesn't parse
+// or allocate with the built-in backends.
+func (s *IPSearch) SearchAddr(addr netip.Addr) *IPRange {
+	if !addr.IsValid() {
+		return nil
+	}
+	c := s.Container()
+	if rs, ok := c.(rawSearcher); ok {
+		return rs.search(addrTo128(addr))
+	}
+	return c.Search(addr.String())
+}
+
+// SearchUint32 search if an integer IPv4 address is in the IP ranges, it
+// doesn't parse or allocate with the built-in backends.
+func (s *IPSearch) SearchUint32(ip uint32) *IPRange {
+	c := s.Container()
+	if rs, ok := c.(rawSearcher); ok {
+		return rs.search(ipv4To128(ip))
+	}
+	return c.Search(IPIntToStr(ip))
+}
+
+// Len returns the number of the IP ranges in the container.
+func (s *IPSearch) Len() int {
+	return s.Container().Len()
+}
+
+// Container returns the current container of the IP ranges, it must not be
+// mutated as it may be in use by the concurrent searches.
+func (s *IPSearch) Container() Container {
+	return s.index.Load().container
 }
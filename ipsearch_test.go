@@ -1,12 +1,21 @@
 package ipsearch_test
 
 import (
+	"bytes"
+	"errors"
 	"net/http"
+	"net/netip"
+	"os"
+	"runtime"
+	"runtime/metrics"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/haoel/ipsearch"
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,6 +37,76 @@ func TestCIDRSearch(t *testing.T) {
 	}
 }
 
+func TestIPv6Search(t *testing.T) {
+	search := ipsearch.NewIPSearch(append(cidrs, cidrs6...), ipsearch.CIDR)
+	for _, data := range testCIDRDataList {
+		ip := search.Search(data.ip)
+		assert.Equal(t, ip != nil, data.find)
+	}
+
+	type testCIDR6Data struct {
+		ip   string
+		cidr string
+	}
+	for _, data := range []testCIDR6Data{
+		{"2001:db8::1", "2001:db8::/32"},
+		{"2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", "2001:db8::/32"},
+		{"2a00:1450:4001:82b::200e", "2a00:1450:4000::/37"},
+		{"240e:3b7::1", "240e::/20"},
+		{"2001:db9::1", ""},
+		{"2a00:1450:4800::1", ""},
+		{"::ffff:1.0.1.24", "1.0.1.0/24"},
+		{"::1", ""},
+	} {
+		ip := search.Search(data.ip)
+		assert.Equal(t, data.cidr != "", ip != nil, data.ip)
+		if ip != nil {
+			assert.Equal(t, data.cidr, ip.CIDR())
+		}
+	}
+
+	search = ipsearch.NewIPSearch(append(geo, geo6...), ipsearch.Geo)
+	ip := search.Search("240e:3b7::1")
+	assert.NotNil(t, ip)
+	assert.Equal(t, "CN", ip.Country())
+	ip = search.Search("2001:200:1::")
+	assert.NotNil(t, ip)
+	assert.Equal(t, "JP", ip.Country())
+	ip = search.Search("1.0.110.10")
+	assert.NotNil(t, ip)
+	assert.Equal(t, "JP", ip.Country())
+	assert.Nil(t, search.Search("2001:201::"))
+}
+
+func TestIPv6WideRanges(t *testing.T) {
+	for _, backend := range []ipsearch.Backend{ipsearch.MapListBackend, ipsearch.TrieBackend, ipsearch.ListBackend} {
+		opts := &ipsearch.LoadOptions{Backend: backend}
+		search, _, err := ipsearch.NewIPSearchWithOptions([]string{"::/0"}, ipsearch.CIDR, opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, search.Search("1.2.3.4"), backend)
+		assert.NotNil(t, search.Search("::1"), backend)
+		assert.NotNil(t, search.Search("2001:db8::1"), backend)
+		assert.NotNil(t, search.Search("ffff::1"), backend)
+		assert.LessOrEqual(t, search.Len(), 258, backend)
+
+		search, _, err = ipsearch.NewIPSearchWithOptions([]string{"2000::/3", "2001:db8:1::/48", "240e::/20", "1.0.1.0/24"}, ipsearch.CIDR,
+			&ipsearch.LoadOptions{Backend: backend, OnOverlap: ipsearch.OverlapMostSpecific})
+		assert.Nil(t, err)
+		assert.Equal(t, "2000::/3", search.Search("2001:db8::1").CIDR(), backend)
+		assert.Equal(t, "2001:db8:1::/48", search.Search("2001:db8:1::1").CIDR(), backend)
+		assert.Equal(t, "240e::/20", search.Search("240e::1").CIDR(), backend)
+		assert.NotNil(t, search.Search("3fff::1"), backend)
+		assert.Nil(t, search.Search("4000::1"), backend)
+		assert.Nil(t, search.Search("1.0.2.1"), backend)
+
+		// the wide ranges are kept in the snapshots
+		loaded, err := ipsearch.LoadSnapshot(bytes.NewReader(snapshot(t, search)))
+		assert.Nil(t, err)
+		assert.NotNil(t, loaded.Search("3fff::1"), backend)
+		assert.Equal(t, "2001:db8:1::/48", loaded.Search("2001:db8:1::1").CIDR(), backend)
+	}
+}
+
 func TestLoadFromFile(t *testing.T) {
 
 	search, err := ipsearch.NewIPSearchWithFile("not-exist-file", ipsearch.CIDR)
@@ -114,3 +193,270 @@ func testGeoSearch(t *testing.T, search *ipsearch.IPSearch) {
 		assert.Equal(t, ip.Country(), data.country)
 	}
 }
+
+func TestSearchAddr(t *testing.T) {
+	for _, backend := range []ipsearch.Backend{ipsearch.MapListBackend, ipsearch.TrieBackend, ipsearch.ListBackend} {
+		opts := &ipsearch.LoadOptions{Backend: backend}
+		search, _, err := ipsearch.NewIPSearchWithOptions(append(cidrs, cidrs6...), ipsearch.CIDR, opts)
+		assert.Nil(t, err)
+		for _, data := range testCIDRDataList {
+			ip := search.SearchAddr(netip.MustParseAddr(data.ip))
+			assert.Equal(t, ip != nil, data.find)
+			if ip != nil {
+				assert.Equal(t, data.cidr, ip.CIDR())
+			}
+			ip = search.SearchUint32(ipsearch.IPStrToInt(data.ip))
+			assert.Equal(t, ip != nil, data.find)
+			if ip != nil {
+				assert.Equal(t, data.cidr, ip.CIDR())
+			}
+		}
+		ip := search.SearchAddr(netip.MustParseAddr("2001:db8::1"))
+		assert.NotNil(t, ip)
+		assert.Equal(t, "2001:db8::/32", ip.CIDR())
+		ip = search.SearchAddr(netip.MustParseAddr("::ffff:1.0.1.1"))
+		assert.NotNil(t, ip)
+		assert.Equal(t, "1.0.1.0/24", ip.CIDR())
+		assert.Nil(t, search.SearchAddr(netip.Addr{}))
+	}
+
+	c := &linearContainer{}
+	c.Insert(ipsearch.NewIPCIDR("10.0.0.0/8"))
+	search := ipsearch.NewIPSearchWithContainer(c)
+	assert.NotNil(t, search.SearchAddr(netip.MustParseAddr("10.1.1.1")))
+	assert.NotNil(t, search.SearchUint32(ipsearch.IPStrToInt("10.1.1.1")))
+
+	m := ipsearch.NewIPRangeMapList()
+	m.AppendBatch(ipsearch.NewIPRangeSlice(cidrs, ipsearch.CIDR))
+	m.Sort()
+	assert.NotNil(t, m.SearchAddr(netip.MustParseAddr("1.0.1.1")))
+	assert.NotNil(t, m.SearchUint32(ipsearch.IPStrToInt("1.0.1.1")))
+	assert.Nil(t, m.SearchUint32(ipsearch.IPStrToInt("5.5.5.5")))
+
+	list := ipsearch.NewIPRangeList(cidrs, ipsearch.CIDR)
+	list.Sort()
+	assert.NotNil(t, list.SearchAddr(netip.MustParseAddr("1.0.1.1")))
+	assert.NotNil(t, list.SearchUint32(ipsearch.IPStrToInt("1.0.1.1")))
+	assert.Nil(t, list.SearchAddr(netip.MustParseAddr("5.5.5.5")))
+}
+
+func TestSearchZeroAlloc(t *testing.T) {
+	log.SetLevel(log.InfoLevel)
+	search, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	assert.Nil(t, err)
+	addr := netip.MustParseAddr("8.8.8.8")
+	ip := ipsearch.IPStrToInt("8.8.8.8")
+
+	assert.Zero(t, testing.AllocsPerRun(100, func() { search.Search("8.8.8.8") }))
+	assert.Zero(t, testing.AllocsPerRun(100, func() { search.Search("2001:db8::1") }))
+	assert.Zero(t, testing.AllocsPerRun(100, func() { search.SearchAddr(addr) }))
+	assert.Zero(t, testing.AllocsPerRun(100, func() { search.SearchUint32(ip) }))
+}
+
+func BenchmarkSearch(b *testing.B) {
+	log.SetLevel(log.InfoLevel)
+	search, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		search.Search(benchIPs[i%len(benchIPs)])
+	}
+}
+
+func BenchmarkSearchAddr(b *testing.B) {
+	log.SetLevel(log.InfoLevel)
+	search, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	if err != nil {
+		b.Fatal(err)
+	}
+	addrs := make([]netip.Addr, len(benchIPs))
+	for i, ip := range benchIPs {
+		addrs[i] = netip.MustParseAddr(ip)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		search.SearchAddr(addrs[i%len(addrs)])
+	}
+}
+
+func BenchmarkSearchUint32(b *testing.B) {
+	log.SetLevel(log.InfoLevel)
+	search, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ips := make([]uint32, len(benchIPs))
+	for i, ip := range benchIPs {
+		ips[i] = ipsearch.IPStrToInt(ip)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		search.SearchUint32(ips[i%len(ips)])
+	}
+}
+
+func TestReload(t *testing.T) {
+	search := ipsearch.NewIPSearch(cidrs, ipsearch.CIDR)
+	assert.Nil(t, search.Search("10.0.0.1"))
+
+	report, err := search.Reload(append(cidrs, "10.0.0.0/8"))
+	assert.Nil(t, err)
+	assert.Nil(t, report)
+	assert.NotNil(t, search.Search("10.0.0.1"))
+	assert.Equal(t, len(cidrs)+1, search.Len())
+
+	// the old index is kept if the loading fails
+	strict, err := ipsearch.NewIPSearchStrict(cidrs, ipsearch.CIDR)
+	assert.Nil(t, err)
+	_, err = strict.Reload([]string{"garbage"})
+	assert.NotNil(t, err)
+	assert.NotNil(t, strict.Search("1.0.1.1"))
+
+	opts := &ipsearch.LoadOptions{OnError: ipsearch.SkipSilently, Backend: ipsearch.TrieBackend}
+	search, _, err = ipsearch.NewIPSearchWithOptions(cidrs, ipsearch.CIDR, opts)
+	assert.Nil(t, err)
+	report, err = search.Reload([]string{"garbage", "10.0.0.0/8"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Skipped))
+	assert.Nil(t, search.Search("1.0.1.1"))
+	assert.NotNil(t, search.Search("10.0.0.1"))
+	_, ok := search.Container().(*ipsearch.IPRangeTrie)
+	assert.True(t, ok)
+
+	_, err = search.ReloadFromFile("not-exist-file")
+	assert.NotNil(t, err)
+	assert.NotNil(t, search.Search("10.0.0.1"))
+	_, err = search.ReloadFromFile(IPv4CIDRFile)
+	assert.Nil(t, err)
+	testCIDRSearch(t, search)
+
+	_, err = ipsearch.NewIPSearchWithContainer(&linearContainer{}).Reload(cidrs)
+	assert.True(t, errors.Is(err, ipsearch.ErrReloadNotSupported))
+}
+
+func TestReloadConcurrently(t *testing.T) {
+	log.SetLevel(log.InfoLevel)
+	search := ipsearch.NewIPSearch(cidrs, ipsearch.CIDR)
+	listA := append([]string{"10.0.0.0/8"}, cidrs...)
+	listB := append([]string{"10.0.0.0/16"}, cidrs...)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// 1.0.1.0/24 is in every version of the list
+				if search.Search("1.0.1.1") == nil {
+					t.Error("1.0.1.1 is not found while reloading")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		lines := listA
+		if i%2 == 1 {
+			lines = listB
+		}
+		_, err := search.Reload(lines)
+		assert.Nil(t, err)
+	}
+	close(done)
+	wg.Wait()
+	assert.Equal(t, "10.0.0.0/16", search.Search("10.0.0.1").CIDR())
+}
+
+func TestNewIPSearchFromReader(t *testing.T) {
+	search, err := ipsearch.NewIPSearchFromReader(strings.NewReader(strings.Join(geo, "\n")), ipsearch.Geo)
+	assert.Nil(t, err)
+	assert.Equal(t, len(geo), search.Len())
+	for _, data := range testGeoDataList {
+		ip := search.Search(data.ip)
+		assert.Equal(t, ip != nil, data.find)
+		if ip != nil {
+			assert.Equal(t, ip.Country(), data.geo)
+		}
+	}
+
+	file, err := os.Open(IPv4GeoFile)
+	assert.Nil(t, err)
+	defer file.Close()
+	search, err = ipsearch.NewIPSearchFromReader(file, ipsearch.Geo)
+	assert.Nil(t, err)
+	testGeoSearch(t, search)
+
+	// the reloading still works
+	_, err = search.Reload(cidrs)
+	assert.Nil(t, err)
+
+	_, err = ipsearch.NewIPSearchFromReader(iotest.ErrReader(errors.New("read error")), ipsearch.CIDR)
+	assert.NotNil(t, err)
+}
+
+// measurePeakHeap reports the peak of the heap while running fn.
+func measurePeakHeap(b *testing.B, fn func()) {
+	samples := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	runtime.GC()
+	metrics.Read(samples)
+	base := samples[0].Value.Uint64()
+	peak := base
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s := []metrics.Sample{{Name: samples[0].Name}}
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			metrics.Read(s)
+			if v := s[0].Value.Uint64(); v > peak {
+				peak = v
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	fn()
+	close(done)
+	<-stopped
+	b.ReportMetric(float64(peak-base), "peak-heap-B")
+}
+
+func BenchmarkLoadGeoLines(b *testing.B) {
+	b.ReportAllocs()
+	measurePeakHeap(b, func() {
+		for i := 0; i < b.N; i++ {
+			lines, err := ipsearch.ReadFile(IPv4GeoFile)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ipsearch.NewIPSearch(lines, ipsearch.Geo)
+		}
+	})
+}
+
+func BenchmarkLoadGeoReader(b *testing.B) {
+	b.ReportAllocs()
+	measurePeakHeap(b, func() {
+		for i := 0; i < b.N; i++ {
+			if _, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
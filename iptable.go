@@ -0,0 +1,212 @@
+package ipsearch
+
+import (
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+)
+
+// IPTable maps the IP ranges to the values of any type, like the backend IDs
+// of a routing layer, so a lookup returns the value instead of an IPRange.
+//
+// It uses the same buckets as IPRangeMapList, the first octet for IPv4 and
+// the first 16 bits for IPv6 with the wide bucket, and the binary search in a
+// bucket. The ranges
+// are expected not to overlap, the constructors resolve the overlaps with
+// LoadOptions.OnOverlap. It is safe for the concurrent lookups, but not for
+// the lookups concurrent with Insert.
+type IPTable[T any] struct {
+	buckets map[uint32][]tableEntry[T]
+}
+
+// tableEntry is a range of an IPTable with the value.
+type tableEntry[T any] struct {
+	start Uint128
+	end   Uint128
+	value T
+}
+
+// NewIPTable creates a new empty IPTable.
+func NewIPTable[T any]() *IPTable[T] {
+	return &IPTable[T]{buckets: make(map[uint32][]tableEntry[T])}
+}
+
+// NewIPTableWithOptions creates a new IPTable from the lines of an IP range
+// list with the options, the value of every loaded range is returned by the
+// value function. The report and the errors are the same as LoadIPRanges.
+func NewIPTableWithOptions[T any](lines []string, rangeType RangeType, opts *LoadOptions, value func(ipRange *IPRange) T) (*IPTable[T], *LoadReport, error) {
+	ipRanges, report, err := LoadIPRanges(lines, rangeType, opts)
+	if err != nil {
+		return nil, report, err
+	}
+	return newIPTable(ipRanges, value), report, nil
+}
+
+// NewIPTableWithFile creates a new IPTable from a file with the default load
+// options, see NewIPTableWithOptions.
+func NewIPTableWithFile[T any](path string, rangeType RangeType, value func(ipRange *IPRange) T) (*IPTable[T], error) {
+	lines, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, _, err := NewIPTableWithOptions(lines, rangeType, nil, value)
+	return t, err
+}
+
+// NewIPTableWithCSV creates a new IPTable from a delimited file, see ReadCSV,
+// the value of every row is returned by the value function.
+func NewIPTableWithCSV[T any](path string, format *CSVFormat, value func(ipRange *IPRange) T) (*IPTable[T], error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return NewIPTableFromCSV(file, format, value)
+}
+
+// NewIPTableFromCSV creates a new IPTable from a reader of a delimited file,
+// see NewIPTableWithCSV.
+func NewIPTableFromCSV[T any](r io.Reader, format *CSVFormat, value func(ipRange *IPRange) T) (*IPTable[T], error) {
+	rc, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	ipRanges, err := ReadCSV(rc, format)
+	if err != nil {
+		return nil, err
+	}
+	return newIPTable(ipRanges, value), nil
+}
+
+// newIPTable builds an IPTable of the ranges, the buckets are sorted once.
+func newIPTable[T any](ipRanges []*IPRange, value func(ipRange *IPRange) T) *IPTable[T] {
+	t := NewIPTable[T]()
+	for _, ipRange := range ipRanges {
+		t.append(ipRange, value(ipRange))
+	}
+	t.sort()
+	return t
+}
+
+// append appends an IP range with the value without keeping the buckets
+// sorted, the buckets must be sorted by sort before the lookups.
+func (t *IPTable[T]) append(ipRange *IPRange, value T) {
+	for _, ip := range ipRange.Split() {
+		t.buckets[ip.bucket] = append(t.buckets[ip.bucket], tableEntry[T]{ip.start, ip.end, value})
+	}
+}
+
+// sort sorts the ranges of every bucket.
+func (t *IPTable[T]) sort() {
+	for _, entries := range t.buckets {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].start.Less(entries[j].start)
+		})
+	}
+}
+
+// Insert inserts an IPv4 or IPv6 CIDR with the value, keeping the buckets
+// sorted.
+func (t *IPTable[T]) Insert(prefix string, value T) error {
+	ipRange, err := ParseCIDR(prefix)
+	if err != nil {
+		return err
+	}
+	t.InsertRange(ipRange, value)
+	return nil
+}
+
+// InsertRange inserts an IP range with the value, the range is split by the
+// buckets, see IPRange.Split.
+func (t *IPTable[T]) InsertRange(ipRange *IPRange, value T) {
+	for _, ip := range ipRange.Split() {
+		entries := t.buckets[ip.bucket]
+		idx := sort.Search(len(entries), func(i int) bool {
+			return !entries[i].start.Less(ip.start)
+		})
+		entries = append(entries, tableEntry[T]{})
+		copy(entries[idx+1:], entries[idx:])
+		entries[idx] = tableEntry[T]{ip.start, ip.end, value}
+		t.buckets[ip.bucket] = entries
+	}
+}
+
+// Lookup returns the value of the range of an IPv4 or IPv6 address, and
+// false if the address is not in the table.
+func (t *IPTable[T]) Lookup(ip string) (T, bool) {
+	return t.lookup(parseIP(ip))
+}
+
+// LookupAddr returns the value of the range of a netip.Addr, see Lookup.
+func (t *IPTable[T]) LookupAddr(addr netip.Addr) (T, bool) {
+	if !addr.IsValid() {
+		var zero T
+		return zero, false
+	}
+	return t.lookup(addrTo128(addr))
+}
+
+// LookupUint32 returns the value of the range of an integer IPv4 address,
+// see Lookup.
+func (t *IPTable[T]) LookupUint32(ip uint32) (T, bool) {
+	return t.lookup(ipv4To128(ip))
+}
+
+func (t *IPTable[T]) lookup(ip Uint128) (T, bool) {
+	if value, ok := t.lookupBucket(bucketOf(ip), ip); ok || ip.IsIPv4() {
+		return value, ok
+	}
+	return t.lookupBucket(wideBucket, ip)
+}
+
+func (t *IPTable[T]) lookupBucket(bucket uint32, ip Uint128) (T, bool) {
+	entries := t.buckets[bucket]
+	start := 0
+	end := len(entries) - 1
+	for start <= end {
+		mid := (start + end) / 2
+		if ipv6InRange(ip, entries[mid].start, entries[mid].end) {
+			return entries[mid].value, true
+		}
+		if ip.Less(entries[mid].start) {
+			end = mid - 1
+		} else {
+			start = mid + 1
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// Len returns the number of the ranges in the table, the split ranges are
+// counted by pieces.
+func (t *IPTable[T]) Len() int {
+	n := 0
+	for _, entries := range t.buckets {
+		n += len(entries)
+	}
+	return n
+}
+
+// Walk calls fn for every range in the table in the order of the addresses,
+// IPv4 first and the wide IPv6 ranges last, with the range in the "start - end" format, see IPRange.Range.
+// It stops when fn returns false.
+func (t *IPTable[T]) Walk(fn func(ipRange string, value T) bool) {
+	buckets := make([]uint32, 0, len(t.buckets))
+	for bucket := range t.buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	for _, bucket := range buckets {
+		for _, e := range t.buckets[bucket] {
+			if !fn(ipToStr(e.start)+" - "+ipToStr(e.end), e.value) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package ipsearch_test
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+type backend struct {
+	ID     int
+	Region string
+}
+
+func TestIPTable(t *testing.T) {
+	table := ipsearch.NewIPTable[int]()
+	assert.Nil(t, table.Insert("10.2.0.0/16", 2))
+	assert.Nil(t, table.Insert("10.1.0.0/16", 1))
+	assert.Nil(t, table.Insert("2001:db8::/32", 6))
+	assert.Nil(t, table.Insert("10.3.0.0/16", 3))
+	assert.True(t, errors.Is(table.Insert("10.4.0.0/33", 4), ipsearch.ErrInvalidCIDR))
+	assert.Equal(t, 4, table.Len())
+
+	for ip, want := range map[string]int{
+		"10.1.0.1":          1,
+		"10.2.255.255":      2,
+		"10.3.0.0":          3,
+		"2001:db8::1":       6,
+		"::ffff:10.1.2.3":   1,
+		"2001:db8:ffff::ff": 6,
+	} {
+		id, ok := table.Lookup(ip)
+		assert.True(t, ok, ip)
+		assert.Equal(t, want, id, ip)
+	}
+	for _, ip := range []string{"10.4.0.1", "11.1.0.1", "2001:db9::1", "garbage"} {
+		id, ok := table.Lookup(ip)
+		assert.False(t, ok, ip)
+		assert.Zero(t, id, ip)
+	}
+
+	id, ok := table.LookupAddr(netip.MustParseAddr("10.2.3.4"))
+	assert.True(t, ok)
+	assert.Equal(t, 2, id)
+	_, ok = table.LookupAddr(netip.Addr{})
+	assert.False(t, ok)
+	id, ok = table.LookupUint32(ipsearch.IPStrToInt("10.3.3.4"))
+	assert.True(t, ok)
+	assert.Equal(t, 3, id)
+
+	// the ranges crossing the buckets are split
+	table.InsertRange(ipsearch.NewIPRange("20.0.0.0,22.127.255.255,US", ipsearch.Geo), 20)
+	id, ok = table.Lookup("21.1.1.1")
+	assert.True(t, ok)
+	assert.Equal(t, 20, id)
+	assert.Equal(t, 7, table.Len())
+
+	var ranges []string
+	table.Walk(func(ipRange string, id int) bool {
+		ranges = append(ranges, ipRange)
+		return len(ranges) < 4
+	})
+	assert.Equal(t, []string{
+		"10.1.0.0 - 10.1.255.255",
+		"10.2.0.0 - 10.2.255.255",
+		"10.3.0.0 - 10.3.255.255",
+		"20.0.0.0 - 20.255.255.255",
+	}, ranges)
+
+	addr := netip.MustParseAddr("10.2.3.4")
+	assert.Zero(t, testing.AllocsPerRun(100, func() { table.Lookup("2001:db8::1") }))
+	assert.Zero(t, testing.AllocsPerRun(100, func() { table.LookupAddr(addr) }))
+}
+
+func TestIPTableConstructors(t *testing.T) {
+	table, err := ipsearch.NewIPTableWithFile(IPv4GeoFile, ipsearch.Geo, func(ip *ipsearch.IPRange) string {
+		return ip.Country()
+	})
+	assert.Nil(t, err)
+	search, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	assert.Nil(t, err)
+	assert.Equal(t, search.Len(), table.Len())
+	for _, ip := range []string{"1.0.1.1", "8.8.8.8", "114.114.114.114", "223.255.255.255"} {
+		country, ok := table.Lookup(ip)
+		assert.Equal(t, search.Search(ip) != nil, ok, ip)
+		if ok {
+			assert.Equal(t, search.Search(ip).Country(), country, ip)
+		}
+	}
+
+	opts := &ipsearch.LoadOptions{OnError: ipsearch.SkipSilently}
+	ids, report, err := ipsearch.NewIPTableWithOptions(dirtyLines, ipsearch.CIDR, opts, func(ip *ipsearch.IPRange) int {
+		return len(ip.CIDR())
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(report.Skipped))
+	id, ok := ids.Lookup("1.0.3.1")
+	assert.True(t, ok)
+	assert.Equal(t, len("1.0.2.0/23"), id)
+
+	_, _, err = ipsearch.NewIPTableWithOptions(dirtyLines, ipsearch.CIDR, nil, func(ip *ipsearch.IPRange) int { return 0 })
+	assert.NotNil(t, err)
+	_, err = ipsearch.NewIPTableWithFile("not-exist-file", ipsearch.CIDR, func(ip *ipsearch.IPRange) int { return 0 })
+	assert.NotNil(t, err)
+	_, err = ipsearch.NewIPTableWithCSV("not-exist-file", &ipsearch.CSVFormat{CIDR: "0"}, func(ip *ipsearch.IPRange) int { return 0 })
+	assert.NotNil(t, err)
+}
+
+func TestIPTableFromCSV(t *testing.T) {
+	rows := "prefix,backend,region\n" +
+		"10.1.0.0/16,1,us-east\n" +
+		"10.2.0.0/16,2,eu-west\n" +
+		"2001:db8::/32,3,ap-south\n"
+	format := &ipsearch.CSVFormat{
+		Header:   true,
+		CIDR:     "prefix",
+		Metadata: map[string]string{"backend": "backend", "region": "region"},
+	}
+	table, err := ipsearch.NewIPTableFromCSV(strings.NewReader(rows), format, func(ip *ipsearch.IPRange) *backend {
+		id := 0
+		for _, c := range ip.Meta("backend") {
+			id = id*10 + int(c-'0')
+		}
+		return &backend{ID: id, Region: ip.Meta("region")}
+	})
+	assert.Nil(t, err)
+	b, ok := table.Lookup("2001:db8::1")
+	assert.True(t, ok)
+	assert.Equal(t, &backend{ID: 3, Region: "ap-south"}, b)
+	b, ok = table.Lookup("10.3.0.1")
+	assert.False(t, ok)
+	assert.Nil(t, b)
+
+	_, err = ipsearch.NewIPTableFromCSV(strings.NewReader(rows), &ipsearch.CSVFormat{CIDR: "prefix"}, func(ip *ipsearch.IPRange) int { return 0 })
+	assert.True(t, errors.Is(err, ipsearch.ErrInvalidCSV))
+}
+
+func BenchmarkIPTableLookup(b *testing.B) {
+	table, err := ipsearch.NewIPTableWithFile(IPv4GeoFile, ipsearch.Geo, func(ip *ipsearch.IPRange) string {
+		return ip.Country()
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.Lookup("114.114.114.114")
+	}
+}
@@ -8,6 +8,11 @@ const (
 
 // IPStrToInt converts a string IP address to an integer.
 func IPStrToInt(ipStr string) uint32 {
+	// the fast path doesn't allocate, fmt.Sscanf is kept for the malformed
+	// addresses, to be compatible with the lenient result.
+	if ip, ok := parseIPv4(ipStr); ok {
+		return ip
+	}
 	var (
 		ip1, ip2, ip3, ip4 uint32
 	)
@@ -48,6 +53,9 @@ func IPInCIDR(ip string, cidr string) bool {
 
 // GetIPSegment returns the segment of an IP address.
 func GetIPSegment(ip string, segment int) uint8 {
+	if v, ok := parseIPv4(ip); ok {
+		return uint8(v >> (32 - 8*segment))
+	}
 	ips := []uint8{0, 0, 0, 0}
 	fmt.Sscanf(ip, ipFmt, &ips[0], &ips[1], &ips[2], &ips[3])
 	return ips[segment-1]
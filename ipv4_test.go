@@ -36,6 +36,11 @@ func TestIP(t *testing.T) {
 	ip = ipsearch.IPStrToInt(ipStr)
 	assert.Equal(t, ip, ipToInt(ipStr))
 	assert.Equal(t, ipsearch.IPIntToStr(ip), intToIP(ip))
+
+	// the malformed addresses keep the lenient result
+	assert.Equal(t, uint32(0), ipsearch.IPStrToInt("garbage"))
+	assert.Equal(t, ipToInt("1.2.3.0"), ipsearch.IPStrToInt("1.2.3"))
+	assert.Zero(t, testing.AllocsPerRun(100, func() { ipsearch.IPStrToInt("192.168.100.200") }))
 }
 
 func TestCIDR(t *testing.T) {
@@ -72,3 +77,10 @@ func TestIPSegment(t *testing.T) {
 	ip = "256.255.255.0"
 	assert.Equal(t, ipsearch.GetIPSegment(ip, 1), uint8(0))
 }
+
+func BenchmarkIPStrToInt(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ipsearch.IPStrToInt("192.168.100.200")
+	}
+}
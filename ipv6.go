@@ -0,0 +1,382 @@
+package ipsearch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Uint128 is a 128-bit unsigned integer, used to represent IPv6 addresses.
+//
+// IPv4 addresses are stored as IPv4-mapped IPv6 addresses (::ffff:a.b.c.d),
+// so both address families share the same ordering.
+type Uint128 struct {
+	Hi uint64
+	Lo uint64
+}
+
+const v4MappedPrefix = 0xffff << 32
+
+// Less reports whether u is less than v.
+func (u Uint128) Less(v Uint128) bool {
+	return u.Hi < v.Hi || (u.Hi == v.Hi && u.Lo < v.Lo)
+}
+
+// Cmp compares u and v and returns -1, 0 or +1.
+func (u Uint128) Cmp(v Uint128) int {
+	switch {
+	case u.Less(v):
+		return -1
+	case v.Less(u):
+		return 1
+	}
+	return 0
+}
+
+// IsIPv4 reports whether u is an IPv4-mapped address.
+func (u Uint128) IsIPv4() bool {
+	return u.Hi == 0 && u.Lo>>32 == 0xffff
+}
+
+// Uint32 returns the low 32 bits of u, the IPv4 address for a mapped value.
+func (u Uint128) Uint32() uint32 {
+	return uint32(u.Lo)
+}
+
+// addOne returns u+1, and false if it overflows.
+func (u Uint128) addOne() (Uint128, bool) {
+	lo := u.Lo + 1
+	hi := u.Hi
+	if lo == 0 {
+		hi++
+	}
+	return Uint128{hi, lo}, hi != 0 || lo != 0
+}
+
+// subOne returns u-1, and false if it underflows.
+func (u Uint128) subOne() (Uint128, bool) {
+	lo := u.Lo - 1
+	hi := u.Hi
+	if u.Lo == 0 {
+		hi--
+	}
+	return Uint128{hi, lo}, u.Hi != 0 || u.Lo != 0
+}
+
+// sub returns u-v, wrapping around on underflow.
+func (u Uint128) sub(v Uint128) Uint128 {
+	lo := u.Lo - v.Lo
+	hi := u.Hi - v.Hi
+	if u.Lo < v.Lo {
+		hi--
+	}
+	return Uint128{hi, lo}
+}
+
+func (u Uint128) or(v Uint128) Uint128 {
+	return Uint128{u.Hi | v.Hi, u.Lo | v.Lo}
+}
+
+func (u Uint128) andNot(v Uint128) Uint128 {
+	return Uint128{u.Hi &^ v.Hi, u.Lo &^ v.Lo}
+}
+
+// hostMask returns the mask of the host bits of a prefix length.
+func hostMask(prefix int) Uint128 {
+	switch {
+	case prefix <= 0:
+		return Uint128{^uint64(0), ^uint64(0)}
+	case prefix >= 128:
+		return Uint128{}
+	case prefix <= 64:
+		return Uint128{^uint64(0) >> prefix, ^uint64(0)}
+	}
+	return Uint128{0, ^uint64(0) >> (prefix - 64)}
+}
+
+// ipv4To128 converts an integer IPv4 address to an IPv4-mapped address.
+func ipv4To128(ip uint32) Uint128 {
+	return Uint128{0, v4MappedPrefix | uint64(ip)}
+}
+
+// addrTo128 converts a netip.Addr to a 128-bit integer, the zone is ignored.
+func addrTo128(addr netip.Addr) Uint128 {
+	b := addr.As16()
+	return Uint128{binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])}
+}
+
+// IsIPv6 checks if an IP address or CIDR string is in IPv6 notation.
+func IsIPv6(ip string) bool {
+	return strings.IndexByte(ip, ':') >= 0
+}
+
+// IPv6StrToInt converts a string IP address to a 128-bit integer.
+// IPv4 addresses are returned in the IPv4-mapped form.
+func IPv6StrToInt(ipStr string) Uint128 {
+	if !IsIPv6(ipStr) {
+		return ipv4To128(IPStrToInt(ipStr))
+	}
+	ip, _ := parseIPv6(ipStr)
+	return ip
+}
+
+// IPv6IntToStr converts a 128-bit integer IP address to a string, in the
+// canonical form of RFC 5952.
+func IPv6IntToStr(ip Uint128) string {
+	var groups [8]uint16
+	for i := 0; i < 4; i++ {
+		groups[i] = uint16(ip.Hi >> (48 - 16*i))
+		groups[i+4] = uint16(ip.Lo >> (48 - 16*i))
+	}
+
+	// find the longest run of zero groups to compress with "::"
+	zeroStart, zeroLen := -1, 0
+	for i := 0; i < 8; i++ {
+		j := i
+		for j < 8 && groups[j] == 0 {
+			j++
+		}
+		if j-i > zeroLen && j-i >= 2 {
+			zeroStart, zeroLen = i, j-i
+		}
+		i = j
+	}
+
+	buf := make([]byte, 0, 39)
+	for i := 0; i < 8; i++ {
+		if i == zeroStart {
+			buf = append(buf, ':', ':')
+			i += zeroLen - 1
+			continue
+		}
+		if i > 0 && buf[len(buf)-1] != ':' {
+			buf = append(buf, ':')
+		}
+		if i == 6 && zeroStart == 0 && zeroLen == 5 && groups[5] == 0xffff {
+			return string(buf) + IPIntToStr(ip.Uint32())
+		}
+		buf = strconv.AppendUint(buf, uint64(groups[i]), 16)
+	}
+	return string(buf)
+}
+
+// IPv6CIDRRange returns the start and end IP addresses for an IPv6 CIDR range.
+func IPv6CIDRRange(cidr string) (Uint128, Uint128) {
+	addr, mask := cidr, 128
+	if i := strings.IndexByte(cidr, '/'); i >= 0 {
+		addr = cidr[:i]
+		if m, err := strconv.Atoi(cidr[i+1:]); err == nil && m >= 0 && m <= 128 {
+			mask = m
+		}
+	}
+	ip, _ := parseIPv6(addr)
+	host := hostMask(mask)
+	return ip.andNot(host), ip.or(host)
+}
+
+// IPv6InCIDR checks if an IPv6 address is in an IPv6 CIDR range.
+func IPv6InCIDR(ip string, cidr string) bool {
+	start, end := IPv6CIDRRange(cidr)
+	return ipv6InRange(IPv6StrToInt(ip), start, end)
+}
+
+func ipv6InRange(ip, start, end Uint128) bool {
+	return !ip.Less(start) && !end.Less(ip)
+}
+
+// parseIP converts an IPv4 or IPv6 string to a 128-bit integer.
+func parseIP(ipStr string) Uint128 {
+	return IPv6StrToInt(ipStr)
+}
+
+// ipToStr converts a 128-bit integer to a string, IPv4-mapped addresses
+// are printed in the dotted IPv4 form.
+func ipToStr(ip Uint128) string {
+	if ip.IsIPv4() {
+		return IPIntToStr(ip.Uint32())
+	}
+	return IPv6IntToStr(ip)
+}
+
+// The IPv4 ranges are bucketed by their first octet (0 - 255), and the IPv6
+// ranges by their first 16 bits, offset by 256 to keep the keys apart.
+const v6BucketBase = 1 << 8
+
+// bucketOf returns the bucket key of an IP address.
+func bucketOf(ip Uint128) uint32 {
+	if ip.IsIPv4() {
+		return ip.Uint32() >> 24
+	}
+	return v6BucketBase + uint32(ip.Hi>>48)
+}
+
+// The IPv6 ranges crossing the buckets are not split, which would be 8192
+// pieces for a /3, they are kept whole in the wide bucket instead, and it is
+// searched when the bucket of an IPv6 address has no range containing it.
+const wideBucket = 1<<32 - 1
+
+// rangeBucket returns the bucket key of an IP range which is not split any
+// more, see IPRange.Split.
+func rangeBucket(start, end Uint128) uint32 {
+	bucket := bucketOf(start)
+	if bucket >= v6BucketBase && bucket != bucketOf(end) {
+		return wideBucket
+	}
+	return bucket
+}
+
+// bucketRange returns the first and the last IP address of a bucket.
+func bucketRange(bucket uint32) (Uint128, Uint128) {
+	if bucket < v6BucketBase {
+		start := bucket << 24
+		return ipv4To128(start), ipv4To128(start | 0x00FFFFFF)
+	}
+	hi := uint64(bucket-v6BucketBase) << 48
+	return Uint128{hi, 0}, Uint128{hi | (1<<48 - 1), ^uint64(0)}
+}
+
+// bucketCIDR returns the CIDR notation of a bucket.
+func bucketCIDR(bucket uint32) string {
+	if bucket < v6BucketBase {
+		return fmt.Sprintf("%d.0.0.0/8", bucket)
+	}
+	start, _ := bucketRange(bucket)
+	return IPv6IntToStr(start) + "/16"
+}
+
+// parseIPv6 parses an IPv6 address, it returns false if the string is not
+// a valid IPv6 address.
+func parseIPv6(s string) (ip Uint128, ok bool) {
+	var groups [8]uint16
+	ellipsis := -1
+
+	if len(s) >= 2 && s[0] == ':' && s[1] == ':' {
+		ellipsis = 0
+		s = s[2:]
+	}
+
+	i := 0
+	for i < 8 && len(s) > 0 {
+		// read a hex group
+		var v uint32
+		n := 0
+		for ; n < len(s); n++ {
+			d, isHex := hexDigit(s[n])
+			if !isHex {
+				break
+			}
+			if n == 4 {
+				return ip, false
+			}
+			v = v<<4 | d
+		}
+		if n == 0 {
+			return ip, false
+		}
+
+		// an embedded IPv4 address must be the last 32 bits
+		if n < len(s) && s[n] == '.' {
+			if (ellipsis < 0 && i != 6) || i > 6 {
+				return ip, false
+			}
+			v4, isV4 := parseIPv4(s)
+			if !isV4 {
+				return ip, false
+			}
+			groups[i] = uint16(v4 >> 16)
+			groups[i+1] = uint16(v4)
+			i += 2
+			s = ""
+			break
+		}
+
+		groups[i] = uint16(v)
+		i++
+		s = s[n:]
+		if len(s) == 0 {
+			break
+		}
+
+		if s[0] != ':' || len(s) == 1 {
+			return ip, false
+		}
+		s = s[1:]
+		if s[0] == ':' {
+			if ellipsis >= 0 {
+				return ip, false
+			}
+			ellipsis = i
+			s = s[1:]
+			if len(s) == 0 {
+				break
+			}
+		}
+	}
+	if len(s) != 0 {
+		return ip, false
+	}
+
+	// expand the "::"
+	if i < 8 {
+		if ellipsis < 0 {
+			return ip, false
+		}
+		n := 8 - i
+		for j := i - 1; j >= ellipsis; j-- {
+			groups[j+n] = groups[j]
+		}
+		for j := ellipsis + n - 1; j >= ellipsis; j-- {
+			groups[j] = 0
+		}
+	} else if ellipsis >= 0 {
+		return ip, false
+	}
+
+	for j := 0; j < 4; j++ {
+		ip.Hi = ip.Hi<<16 | uint64(groups[j])
+		ip.Lo = ip.Lo<<16 | uint64(groups[j+4])
+	}
+	return ip, true
+}
+
+// parseIPv4 parses a dotted IPv4 address, it returns false if the string is
+// not a valid IPv4 address.
+func parseIPv4(s string) (uint32, bool) {
+	var ip uint32
+	for i := 0; i < 4; i++ {
+		if i > 0 {
+			if len(s) == 0 || s[0] != '.' {
+				return 0, false
+			}
+			s = s[1:]
+		}
+		var v uint32
+		n := 0
+		for ; n < len(s) && s[n] >= '0' && s[n] <= '9'; n++ {
+			if n == 3 {
+				return 0, false
+			}
+			v = v*10 + uint32(s[n]-'0')
+		}
+		if n == 0 || v > 255 {
+			return 0, false
+		}
+		s = s[n:]
+		ip = ip<<8 | v
+	}
+	return ip, len(s) == 0
+}
+
+func hexDigit(c byte) (uint32, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return uint32(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return uint32(c - 'a' + 10), true
+	case c >= 'A' && c <= 'F':
+		return uint32(c - 'A' + 10), true
+	}
+	return 0, false
+}
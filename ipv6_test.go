@@ -0,0 +1,73 @@
+package ipsearch_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/haoel/ipsearch"
+	"github.com/stretchr/testify/assert"
+)
+
+func ipv6ToInt(ipStr string) ipsearch.Uint128 {
+	ip := net.ParseIP(ipStr).To16()
+	return ipsearch.Uint128{
+		Hi: binary.BigEndian.Uint64(ip[:8]),
+		Lo: binary.BigEndian.Uint64(ip[8:]),
+	}
+}
+
+func TestIPv6(t *testing.T) {
+	ips := []string{
+		"2001:db8::1",
+		"2001:db8:85a3::8a2e:370:7334",
+		"fe80::",
+		"::1",
+		"::",
+		"1::",
+		"2001:db8:0:1:1:1:1:1",
+		"2001:0:0:1::1",
+		"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff",
+	}
+	for _, ipStr := range ips {
+		ip := ipsearch.IPv6StrToInt(ipStr)
+		assert.Equal(t, ipv6ToInt(ipStr), ip)
+		assert.Equal(t, ipStr, ipsearch.IPv6IntToStr(ip))
+	}
+
+	ip := ipsearch.IPv6StrToInt("2001:DB8:0000:0000:0:0:0:0001")
+	assert.Equal(t, "2001:db8::1", ipsearch.IPv6IntToStr(ip))
+
+	ip = ipsearch.IPv6StrToInt("::ffff:1.2.3.4")
+	assert.True(t, ip.IsIPv4())
+	assert.Equal(t, ipsearch.IPStrToInt("1.2.3.4"), ip.Uint32())
+	assert.Equal(t, "::ffff:1.2.3.4", ipsearch.IPv6IntToStr(ip))
+	assert.Equal(t, ip, ipsearch.IPv6StrToInt("1.2.3.4"))
+
+	for _, bad := range []string{"1:2:3", "1::2::3", "12345::", "1:2:3:4:5:6:7:8:9", ":1::", "1:"} {
+		assert.Equal(t, ipsearch.Uint128{}, ipsearch.IPv6StrToInt(bad), bad)
+	}
+}
+
+func TestIPv6CIDR(t *testing.T) {
+	ipCIDR := "2001:db8::/32"
+	start, end := ipsearch.IPv6CIDRRange(ipCIDR)
+	assert.Equal(t, ipv6ToInt("2001:db8::"), start)
+	assert.Equal(t, ipv6ToInt("2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"), end)
+
+	assert.True(t, ipsearch.IPv6InCIDR("2001:db8:1::1", ipCIDR))
+	assert.False(t, ipsearch.IPv6InCIDR("2001:db9::1", ipCIDR))
+
+	start, end = ipsearch.IPv6CIDRRange("2001:db8::1:2:3:4/96")
+	assert.Equal(t, ipv6ToInt("2001:db8::1:2:0:0"), start)
+	assert.Equal(t, ipv6ToInt("2001:db8::1:2:ffff:ffff"), end)
+
+	ipCIDR = "2001:db8::1"
+	start, end = ipsearch.IPv6CIDRRange(ipCIDR)
+	assert.Equal(t, ipv6ToInt(ipCIDR), start)
+	assert.Equal(t, ipv6ToInt(ipCIDR), end)
+
+	start, end = ipsearch.IPv6CIDRRange("::/0")
+	assert.Equal(t, ipsearch.Uint128{}, start)
+	assert.Equal(t, ipsearch.Uint128{Hi: ^uint64(0), Lo: ^uint64(0)}, end)
+}
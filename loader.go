@@ -0,0 +1,127 @@
+package ipsearch
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrorPolicy decides what the loader does with a malformed line.
+type ErrorPolicy int
+
+const (
+	// FailFast stops loading at the first malformed line.
+	FailFast ErrorPolicy = iota
+	// SkipAndLog skips the malformed lines and logs them as warnings.
+	SkipAndLog
+	// SkipSilently skips the malformed lines without logging.
+	SkipSilently
+)
+
+// LoadOptions configures how the lines of an IP range list are loaded.
+//
+// The UTF-8 BOM, the trailing '\r', the blank lines and the comment lines
+// (starting with '#') are always skipped, they are counted in the LoadReport.
+type LoadOptions struct {
+	// OnError decides what to do with a malformed line.
+	OnError ErrorPolicy
+	// OnOverlap decides how the overlapping ranges are resolved.
+	OnOverlap OverlapPolicy
+	// Backend is the index structure of the IPSearch.
+	Backend Backend
+	// NewContainer creates a custom index structure of the IPSearch, the
+	// ranges are added with Container.Insert. It overrides the Backend.
+	NewContainer func() Container
+	// Annotate returns the metadata added to each parsed range, like the
+	// tags and the scores kept by the CIDR, see IPRange.WithMetadata. It is
+	// called before the ranges are split, the same metadata is shared.
+	Annotate func(ipRange *IPRange) map[string]string
+}
+
+// LoadReport describes the data quality of a loaded IP range list.
+type LoadReport struct {
+	LinesRead    int         // all of the lines read
+	Comments     int         // comment lines skipped
+	BlankLines   int         // blank lines skipped
+	Ignored      int         // valid lines without an IP range, like the headers
+	RangesLoaded int         // ranges parsed and loaded
+	RangesSplit  int         // loaded ranges split by IPRange.Split
+	Skipped      ParseErrors // malformed lines skipped, with the reasons
+	Overlaps     []Overlap   // overlapping ranges found
+}
+
+const utf8BOM = "\uFEFF"
+
+// LoadIPRanges parses the lines of an IP range list with the options, a nil
+// options means the default options.
+// It returns the ranges, a report of the loading, and an error if the
+// FailFast policy meets a malformed line or the OverlapReject policy meets
+// overlapping ranges, or ErrInvalidRangeType for the range type of a binary
+// file, like MMDB or IP2Region.
+func LoadIPRanges(lines []string, rangeType RangeType, opts *LoadOptions) ([]*IPRange, *LoadReport, error) {
+	if err := checkLineRangeType(rangeType); err != nil {
+		return nil, nil, err
+	}
+	if opts == nil {
+		opts = &LoadOptions{}
+	}
+	var err error
+	report := &LoadReport{}
+	ipRanges := make([]*IPRange, 0, len(lines))
+	metas := make(metadataInterner)
+	for i, line := range lines {
+		report.LinesRead++
+		if i == 0 {
+			line = strings.TrimPrefix(line, utf8BOM)
+		}
+		line = strings.TrimSuffix(line, "\r")
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			report.BlankLines++
+			continue
+		}
+		if trimmed[0] == '#' {
+			report.Comments++
+			continue
+		}
+
+		var ipRange *IPRange
+		ipRange, err = ParseIPRange(trimmed, rangeType)
+		if err != nil {
+			lineErr := &LineError{Line: i + 1, Text: line, Err: err}
+			switch opts.OnError {
+			case FailFast:
+				return nil, report, lineErr
+			case SkipAndLog:
+				log.Warnf("Skip the malformed line: %v", lineErr)
+			}
+			report.Skipped = append(report.Skipped, lineErr)
+			continue
+		}
+		if ipRange == nil {
+			report.Ignored++
+			continue
+		}
+
+		if opts.Annotate != nil {
+			if m := opts.Annotate(ipRange); len(m) > 0 {
+				ipRange.meta = metas.intern(ipRange.meta.with(m))
+			}
+		}
+
+		report.RangesLoaded++
+		ipRanges = append(ipRanges, ipRange)
+	}
+
+	ipRanges, report.Overlaps, err = ResolveOverlaps(ipRanges, opts.OnOverlap)
+	if err != nil {
+		return nil, report, err
+	}
+	for _, ipRange := range ipRanges {
+		if ipRange.needSplit() {
+			report.RangesSplit++
+		}
+	}
+	return ipRanges, report, nil
+}
@@ -0,0 +1,120 @@
+package ipsearch_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+var dirtyLines = []string{
+	"\uFEFF# China IP list",
+	"1.0.1.0/24\r",
+	"",
+	"   ",
+	"1.0.2.0/23",
+	"garbage",
+	"  # another comment",
+	"1.4.1.0/24",
+	"1.0.8.0",
+	"2.0.0.0/7",
+}
+
+func TestLoadIPRanges(t *testing.T) {
+	ipRanges, report, err := ipsearch.LoadIPRanges(dirtyLines, ipsearch.CIDR, nil)
+	assert.Nil(t, ipRanges)
+	var lineErr *ipsearch.LineError
+	assert.True(t, errors.As(err, &lineErr))
+	assert.Equal(t, 6, lineErr.Line)
+	assert.Equal(t, 6, report.LinesRead)
+	assert.Equal(t, 2, report.RangesLoaded)
+
+	for _, policy := range []ipsearch.ErrorPolicy{ipsearch.SkipAndLog, ipsearch.SkipSilently} {
+		opts := &ipsearch.LoadOptions{OnError: policy}
+		ipRanges, report, err = ipsearch.LoadIPRanges(dirtyLines, ipsearch.CIDR, opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(ipRanges))
+		assert.Equal(t, "1.0.1.0/24", ipRanges[0].CIDR())
+		assert.Equal(t, len(dirtyLines), report.LinesRead)
+		assert.Equal(t, 2, report.Comments)
+		assert.Equal(t, 2, report.BlankLines)
+		assert.Equal(t, 4, report.RangesLoaded)
+		assert.Equal(t, 1, report.RangesSplit)
+		assert.Equal(t, 2, len(report.Skipped))
+		assert.Equal(t, 6, report.Skipped[0].Line)
+		assert.Equal(t, 9, report.Skipped[1].Line)
+		assert.True(t, errors.Is(report.Skipped[1], ipsearch.ErrInvalidCIDR))
+	}
+}
+
+func TestNewIPSearchWithOptions(t *testing.T) {
+	opts := &ipsearch.LoadOptions{OnError: ipsearch.SkipSilently}
+	search, report, err := ipsearch.NewIPSearchWithOptions(dirtyLines, ipsearch.CIDR, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(report.Skipped))
+	ip := search.Search("1.0.1.1")
+	assert.NotNil(t, ip)
+	assert.Equal(t, "1.0.1.0/24", ip.CIDR())
+	ip = search.Search("3.1.1.1")
+	assert.NotNil(t, ip)
+	assert.Nil(t, search.Search("1.0.8.1"))
+
+	search, _, err = ipsearch.NewIPSearchWithOptions(dirtyLines, ipsearch.CIDR, nil)
+	assert.Nil(t, search)
+	assert.NotNil(t, err)
+
+	_, _, err = ipsearch.NewIPSearchWithFileOptions("not-exist-file", ipsearch.Geo, nil)
+	assert.NotNil(t, err)
+
+	search, report, err = ipsearch.NewIPSearchWithFileOptions(IPv4GeoFile, ipsearch.Geo, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, getFilesLineNum(IPv4GeoFile), report.LinesRead)
+	assert.Equal(t, report.LinesRead, report.RangesLoaded)
+	assert.Empty(t, report.Skipped)
+	testGeoSearch(t, search)
+}
+
+func TestLoadAnnotate(t *testing.T) {
+	tags := map[string]map[string]string{
+		"1.0.1.0/24": {"dc": "sha1", "risk": "0.2"},
+		"1.0.2.0/23": {"dc": "sha1", "risk": "0.2"},
+		"2.0.0.0/7":  {"dc": "fra2"},
+	}
+	opts := &ipsearch.LoadOptions{
+		OnError: ipsearch.SkipSilently,
+		Annotate: func(ip *ipsearch.IPRange) map[string]string {
+			return tags[ip.CIDR()]
+		},
+	}
+	search, _, err := ipsearch.NewIPSearchWithOptions(dirtyLines, ipsearch.CIDR, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "sha1", search.Search("1.0.1.1").Meta("dc"))
+	assert.Equal(t, "0.2", search.Search("1.0.3.1").Meta("risk"))
+	// the pieces of the split range keep the metadata
+	assert.Equal(t, "fra2", search.Search("2.1.1.1").Meta("dc"))
+	assert.Equal(t, "fra2", search.Search("3.1.1.1").Meta("dc"))
+	assert.Nil(t, search.Search("1.4.1.1").Metadata())
+
+	// the metadata survives the snapshot, the mapped index and the MMDB file
+	loaded, err := ipsearch.LoadSnapshot(bytes.NewReader(snapshot(t, search)))
+	assert.Nil(t, err)
+	mapped, idx, err := ipsearch.NewIPSearchWithMappedIndex(writeMappedIndex(t, search))
+	assert.Nil(t, err)
+	defer idx.Close()
+	fromMMDB, err := ipsearch.NewIPSearchFromMMDB(bytes.NewReader(writeMMDB(t, search, nil)))
+	assert.Nil(t, err)
+	for _, other := range []*ipsearch.IPSearch{loaded, mapped, fromMMDB} {
+		for _, ip := range []string{"1.0.1.1", "1.0.3.1", "2.1.1.1", "3.1.1.1", "1.4.1.1"} {
+			assert.Equal(t, search.Search(ip).Metadata(), other.Search(ip).Metadata(), ip)
+		}
+	}
+
+	// the reload annotates the new ranges
+	tags["1.0.1.0/24"] = map[string]string{"dc": "sha2"}
+	_, err = search.Reload(dirtyLines)
+	assert.Nil(t, err)
+	assert.Equal(t, "sha2", search.Search("1.0.1.1").Meta("dc"))
+}
@@ -0,0 +1,110 @@
+package ipsearch
+
+import (
+	"sort"
+	"strings"
+)
+
+// metadata is the extra fields of an IP range, like the region and the city.
+// It is shared by the ranges with the same fields, and never mutated.
+type metadata map[string]string
+
+// metadataInterner shares the metadata with the same fields, the keys are the
+// encoded metadata.
+type metadataInterner map[string]metadata
+
+// intern returns the shared metadata with the same fields as m.
+func (in metadataInterner) intern(m metadata) metadata {
+	if len(m) == 0 {
+		return nil
+	}
+	key := m.encode()
+	if shared, ok := in[key]; ok {
+		return shared
+	}
+	in[key] = m
+	return m
+}
+
+// The keys of the well-known metadata.
+const (
+	metaCountryName = "country_name"
+	metaRegion      = "region"
+	metaCity        = "city"
+	metaISP         = "isp"
+	metaRegistry    = "registry"
+	metaStatus      = "status"
+	metaDate        = "date"
+)
+
+// encode returns the metadata as a string, the sorted keys and the values
+// are separated by NUL, for the serialization.
+func (m metadata) encode() string {
+	if len(m) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(0)
+		}
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(m[k])
+	}
+	return b.String()
+}
+
+// decodeMetadata decodes a string returned by metadata.encode.
+func decodeMetadata(s string) metadata {
+	if s == "" {
+		return nil
+	}
+	fields := strings.Split(s, "\x00")
+	m := make(metadata, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		m[fields[i]] = fields[i+1]
+	}
+	return m
+}
+
+// with returns a copy of the metadata with the fields of m, the empty
+// values remove the fields, it returns nil if no field is left.
+func (md metadata) with(m map[string]string) metadata {
+	merged := make(metadata, len(md)+len(m))
+	for k, v := range md {
+		merged[k] = v
+	}
+	for k, v := range m {
+		if v == "" {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// newMetadata creates the metadata of the key and value pairs, the empty
+// values are skipped, it returns nil if all of them are empty.
+func newMetadata(kvs ...string) metadata {
+	var m metadata
+	for i := 0; i+1 < len(kvs); i += 2 {
+		if kvs[i+1] == "" {
+			continue
+		}
+		if m == nil {
+			m = make(metadata)
+		}
+		m[kvs[i]] = kvs[i+1]
+	}
+	return m
+}
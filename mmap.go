@@ -0,0 +1,354 @@
+package ipsearch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+)
+
+// The mapped index is a read-only on-disk layout, which is searched in place
+// after it is memory-mapped, all of the integers are little endian:
+//
+//	header      64 bytes, see below
+//	offsets     257 uint32, the IPv4 records of the first octet b are
+//	            records[offsets[b]:offsets[b+1]]
+//	v4 records  start, end, country, cidr, type, asn, org and meta, 8 uint32
+//	            each
+//	v6 records  start, end (2 uint64 each), country, cidr, type, asn, org
+//	            and meta (uint32 each)
+//	strings     uint16 length and bytes for each string
+//
+// The records are sorted by the start addresses, the country, cidr, org and
+// meta (the encoded metadata) are the offsets in the strings, the offset 0
+// is always the empty string.
+//
+// The header is the magic "IPSINDEX", the uint32 version, the uint32 counts
+// of the v4 and v6 records, a padding uint32, and the uint64 offsets of the
+// v4 records, the v6 records and the strings in the file.
+const (
+	mappedMagic      = "IPSINDEX"
+	mappedVersion    = 3
+	mappedHeaderSize = 64
+	mappedOffsets    = 257
+	mappedV4Size     = 32
+	mappedV6Size     = 56
+)
+
+// ErrBadIndex is returned when a mapped index is corrupted or unsupported.
+var ErrBadIndex = errors.New("bad mapped index")
+
+// WriteMappedIndex writes the ranges of the IPSearch to a mapped index,
+// which can be opened by OpenMappedIndex.
+func (s *IPSearch) WriteMappedIndex(w io.Writer) error {
+	v4, v6 := splitSorted(s.Container())
+
+	strIndex := map[string]uint32{"": 0}
+	strs := []byte{0, 0}
+	intern := func(str string) (uint32, error) {
+		off, ok := strIndex[str]
+		if ok {
+			return off, nil
+		}
+		if len(str) > 0xFFFF {
+			return 0, fmt.Errorf("%w: string too long: %q...", ErrBadIndex, str[:32])
+		}
+		off = uint32(len(strs))
+		strIndex[str] = off
+		strs = binary.LittleEndian.AppendUint16(strs, uint16(len(str)))
+		strs = append(strs, str...)
+		return off, nil
+	}
+	appendRecord := func(buf []byte, ip *IPRange) ([]byte, error) {
+		country, err := intern(ip.country)
+		if err != nil {
+			return nil, err
+		}
+		cidr, err := intern(ip.cidr)
+		if err != nil {
+			return nil, err
+		}
+		org, err := intern(ip.org)
+		if err != nil {
+			return nil, err
+		}
+		meta, err := intern(ip.meta.encode())
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, country)
+		buf = binary.LittleEndian.AppendUint32(buf, cidr)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(ip.rangeType))
+		buf = binary.LittleEndian.AppendUint32(buf, ip.asn)
+		buf = binary.LittleEndian.AppendUint32(buf, org)
+		return binary.LittleEndian.AppendUint32(buf, meta), nil
+	}
+
+	v4Off := mappedHeaderSize + mappedOffsets*4
+	v6Off := v4Off + len(v4)*mappedV4Size
+	v6Off += -v6Off & 7
+	strOff := v6Off + len(v6)*mappedV6Size
+
+	buf := make([]byte, v6Off, strOff)
+	copy(buf, mappedMagic)
+	binary.LittleEndian.PutUint32(buf[8:], mappedVersion)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(v4)))
+	binary.LittleEndian.PutUint32(buf[16:], uint32(len(v6)))
+	binary.LittleEndian.PutUint64(buf[24:], uint64(v4Off))
+	binary.LittleEndian.PutUint64(buf[32:], uint64(v6Off))
+	binary.LittleEndian.PutUint64(buf[40:], uint64(strOff))
+
+	// the offsets of the first octets
+	octet := 0
+	for i, ip := range v4 {
+		for ; octet <= int(ip.bucket); octet++ {
+			binary.LittleEndian.PutUint32(buf[mappedHeaderSize+octet*4:], uint32(i))
+		}
+	}
+	for ; octet < mappedOffsets; octet++ {
+		binary.LittleEndian.PutUint32(buf[mappedHeaderSize+octet*4:], uint32(len(v4)))
+	}
+
+	var err error
+	records := buf[:v4Off]
+	for _, ip := range v4 {
+		records = binary.LittleEndian.AppendUint32(records, ip.start.Uint32())
+		records = binary.LittleEndian.AppendUint32(records, ip.end.Uint32())
+		if records, err = appendRecord(records, ip); err != nil {
+			return err
+		}
+	}
+	records = buf[:v6Off]
+	for _, ip := range v6 {
+		records = append128(records, ip.start)
+		records = append128(records, ip.end)
+		if records, err = appendRecord(records, ip); err != nil {
+			return err
+		}
+	}
+	buf = append(records, strs...)
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// MappedIndex is a read-only Container searching a mapped index in place, the
+// processes opening the same file share the page cache, and the heap
+// footprint is near zero. The IP ranges returned are copied out of the file.
+//
+// It is safe for concurrent use, but must not be used after Close.
+type MappedIndex struct {
+	data    []byte
+	unmap   func() error
+	offsets []byte
+	v4, v6  []byte
+	strs    []byte
+	n4, n6  int
+}
+
+var _ Container = &MappedIndex{}
+
+// OpenMappedIndex memory-maps a file written by WriteMappedIndex, the file is
+// read into the memory on the platforms without mmap.
+func OpenMappedIndex(path string) (*MappedIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, unmap, err := mmapFile(file)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := newMappedIndex(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	idx.unmap = unmap
+	return idx, nil
+}
+
+// NewIPSearchWithMappedIndex creates a new IPSearch struct from a mapped
+// index file, see OpenMappedIndex, the IPSearch cannot be reloaded.
+func NewIPSearchWithMappedIndex(path string) (*IPSearch, *MappedIndex, error) {
+	idx, err := OpenMappedIndex(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewIPSearchWithContainer(idx), idx, nil
+}
+
+func newMappedIndex(data []byte) (*MappedIndex, error) {
+	if len(data) < mappedHeaderSize+mappedOffsets*4 || string(data[:len(mappedMagic)]) != mappedMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrBadIndex)
+	}
+	if version := binary.LittleEndian.Uint32(data[8:]); version != mappedVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadIndex, version)
+	}
+	n4 := uint64(binary.LittleEndian.Uint32(data[12:]))
+	n6 := uint64(binary.LittleEndian.Uint32(data[16:]))
+	v4Off := binary.LittleEndian.Uint64(data[24:])
+	v6Off := binary.LittleEndian.Uint64(data[32:])
+	strOff := binary.LittleEndian.Uint64(data[40:])
+	if v4Off < mappedHeaderSize+mappedOffsets*4 || v4Off+n4*mappedV4Size > v6Off ||
+		v6Off+n6*mappedV6Size != strOff || strOff > uint64(len(data)) {
+		return nil, fmt.Errorf("%w: bad sections", ErrBadIndex)
+	}
+
+	idx := &MappedIndex{
+		data:    data,
+		offsets: data[mappedHeaderSize : mappedHeaderSize+mappedOffsets*4],
+		v4:      data[v4Off : v4Off+n4*mappedV4Size],
+		v6:      data[v6Off:strOff],
+		strs:    data[strOff:],
+		n4:      int(n4),
+		n6:      int(n6),
+	}
+	prev := uint32(0)
+	for b := 0; b < mappedOffsets; b++ {
+		off := idx.offset(b)
+		if off < prev || off > uint32(n4) {
+			return nil, fmt.Errorf("%w: bad offsets", ErrBadIndex)
+		}
+		prev = off
+	}
+	return idx, nil
+}
+
+// Close unmaps the file.
+func (idx *MappedIndex) Close() error {
+	if idx.unmap == nil {
+		return nil
+	}
+	unmap := idx.unmap
+	*idx = MappedIndex{}
+	return unmap()
+}
+
+// Insert panics, the mapped index is read-only.
+func (idx *MappedIndex) Insert(ipRange *IPRange) {
+	panic("ipsearch: MappedIndex is read-only")
+}
+
+// Search search if an IP address is in the mapped index.
+func (idx *MappedIndex) Search(ipStr string) *IPRange {
+	return idx.search(parseIP(ipStr))
+}
+
+// SearchAddr search if a netip.Addr is in the mapped index.
+func (idx *MappedIndex) SearchAddr(addr netip.Addr) *IPRange {
+	if !addr.IsValid() {
+		return nil
+	}
+	return idx.search(addrTo128(addr))
+}
+
+// SearchUint32 search if an integer IPv4 address is in the mapped index.
+func (idx *MappedIndex) SearchUint32(ip uint32) *IPRange {
+	return idx.search(ipv4To128(ip))
+}
+
+func (idx *MappedIndex) search(ip Uint128) *IPRange {
+	if ip.IsIPv4() {
+		v := ip.Uint32()
+		b := int(v >> 24)
+		lo, hi := int(idx.offset(b)), int(idx.offset(b+1))-1
+		for lo <= hi {
+			mid := (lo + hi) / 2
+			rec := idx.v4[mid*mappedV4Size:]
+			switch {
+			case v < binary.LittleEndian.Uint32(rec):
+				hi = mid - 1
+			case v > binary.LittleEndian.Uint32(rec[4:]):
+				lo = mid + 1
+			default:
+				return idx.v4Range(mid)
+			}
+		}
+		return nil
+	}
+
+	lo, hi := 0, idx.n6-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		rec := idx.v6[mid*mappedV6Size:]
+		switch {
+		case ip.Less(read128(rec)):
+			hi = mid - 1
+		case read128(rec[16:]).Less(ip):
+			lo = mid + 1
+		default:
+			return idx.v6Range(mid)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of the IP ranges in the mapped index, the split
+// ranges are counted by pieces.
+func (idx *MappedIndex) Len() int {
+	return idx.n4 + idx.n6
+}
+
+// Walk calls fn for every IP range in the mapped index in the order of the
+// addresses, IPv4 first, it stops when fn returns false.
+func (idx *MappedIndex) Walk(fn func(*IPRange) bool) {
+	for i := 0; i < idx.n4; i++ {
+		if !fn(idx.v4Range(i)) {
+			return
+		}
+	}
+	for i := 0; i < idx.n6; i++ {
+		if !fn(idx.v6Range(i)) {
+			return
+		}
+	}
+}
+
+func (idx *MappedIndex) offset(b int) uint32 {
+	return binary.LittleEndian.Uint32(idx.offsets[b*4:])
+}
+
+func (idx *MappedIndex) v4Range(i int) *IPRange {
+	rec := idx.v4[i*mappedV4Size:]
+	start := ipv4To128(binary.LittleEndian.Uint32(rec))
+	end := ipv4To128(binary.LittleEndian.Uint32(rec[4:]))
+	return idx.newRange(start, end, rec[8:])
+}
+
+func (idx *MappedIndex) v6Range(i int) *IPRange {
+	rec := idx.v6[i*mappedV6Size:]
+	return idx.newRange(read128(rec), read128(rec[16:]), rec[32:])
+}
+
+// newRange copies a record out of the file, meta is the country, cidr, type,
+// asn, org and meta of the record.
+func (idx *MappedIndex) newRange(start, end Uint128, meta []byte) *IPRange {
+	return &IPRange{
+		rangeType: RangeType(binary.LittleEndian.Uint32(meta[8:])),
+		bucket:    rangeBucket(start, end),
+		start:     start,
+		end:       end,
+		country:   idx.str(binary.LittleEndian.Uint32(meta)),
+		cidr:      idx.str(binary.LittleEndian.Uint32(meta[4:])),
+		asn:       binary.LittleEndian.Uint32(meta[12:]),
+		org:       idx.str(binary.LittleEndian.Uint32(meta[16:])),
+		meta:      decodeMetadata(idx.str(binary.LittleEndian.Uint32(meta[20:]))),
+	}
+}
+
+// str returns a copy of the string at an offset, or "" if it is out of range.
+func (idx *MappedIndex) str(off uint32) string {
+	if uint64(off)+2 > uint64(len(idx.strs)) {
+		return ""
+	}
+	n := uint64(binary.LittleEndian.Uint16(idx.strs[off:]))
+	if uint64(off)+2+n > uint64(len(idx.strs)) {
+		return ""
+	}
+	return string(idx.strs[off+2 : uint64(off)+2+n])
+}
@@ -0,0 +1,102 @@
+package ipsearch_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+func writeMappedIndex(t testing.TB, search *ipsearch.IPSearch) string {
+	path := filepath.Join(t.TempDir(), "index")
+	file, err := os.Create(path)
+	assert.Nil(t, err)
+	assert.Nil(t, search.WriteMappedIndex(file))
+	assert.Nil(t, file.Close())
+	return path
+}
+
+func TestMappedIndex(t *testing.T) {
+	search, err := ipsearch.NewIPSearchWithFile(IPv4CIDRFile, ipsearch.CIDR)
+	assert.Nil(t, err)
+	mapped, idx, err := ipsearch.NewIPSearchWithMappedIndex(writeMappedIndex(t, search))
+	assert.Nil(t, err)
+	defer idx.Close()
+	assert.Equal(t, search.Len(), mapped.Len())
+	assert.Equal(t, walkRanges(search), walkRanges(mapped))
+	testCIDRSearch(t, mapped)
+
+	search, err = ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	assert.Nil(t, err)
+	mapped, idx, err = ipsearch.NewIPSearchWithMappedIndex(writeMappedIndex(t, search))
+	assert.Nil(t, err)
+	defer idx.Close()
+	assert.Equal(t, walkRanges(search), walkRanges(mapped))
+	testGeoSearch(t, mapped)
+	for _, ip := range benchIPs {
+		assert.Equal(t, search.SearchUint32(ipsearch.IPStrToInt(ip)), mapped.SearchUint32(ipsearch.IPStrToInt(ip)), ip)
+	}
+
+	assert.Panics(t, func() { idx.Insert(ipsearch.NewIPCIDR("1.0.0.0/24")) })
+	assert.Nil(t, idx.Close())
+}
+
+func TestMappedIndexIPv6(t *testing.T) {
+	search := ipsearch.NewIPSearch(append(geo6, geo...), ipsearch.Geo)
+	mapped, idx, err := ipsearch.NewIPSearchWithMappedIndex(writeMappedIndex(t, search))
+	assert.Nil(t, err)
+	defer idx.Close()
+	assert.Equal(t, walkRanges(search), walkRanges(mapped))
+	for _, ip := range []string{"2001:db8::1", "2a00:1450:4001:82b::200e", "240e:3b7::1", "1.0.1.24", "8.8.8.8", "::1", "ffff::1"} {
+		assert.Equal(t, search.Search(ip), mapped.Search(ip), ip)
+	}
+}
+
+func TestMappedIndexNested(t *testing.T) {
+	search, _, err := ipsearch.NewIPSearchWithOptions(nestedCIDRs, ipsearch.CIDR, &ipsearch.LoadOptions{Backend: ipsearch.TrieBackend})
+	assert.Nil(t, err)
+	mapped, idx, err := ipsearch.NewIPSearchWithMappedIndex(writeMappedIndex(t, search))
+	assert.Nil(t, err)
+	defer idx.Close()
+	assertNestedSearch(t, mapped)
+	assertNestedSearch(t, idx)
+}
+
+func TestMappedIndexCorrupted(t *testing.T) {
+	_, err := ipsearch.OpenMappedIndex("not-exist-file")
+	assert.NotNil(t, err)
+
+	data, err := os.ReadFile(writeMappedIndex(t, ipsearch.NewIPSearch(cidrs, ipsearch.CIDR)))
+	assert.Nil(t, err)
+	for name, bad := range map[string][]byte{
+		"empty":     {},
+		"magic":     append([]byte("NOTMAGIC"), data[8:]...),
+		"truncated": data[:len(data)/2],
+	} {
+		path := filepath.Join(t.TempDir(), name)
+		assert.Nil(t, os.WriteFile(path, bad, 0o644))
+		_, err := ipsearch.OpenMappedIndex(path)
+		assert.True(t, errors.Is(err, ipsearch.ErrBadIndex), name)
+	}
+}
+
+func BenchmarkMappedIndexSearch(b *testing.B) {
+	search, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	if err != nil {
+		b.Fatal(err)
+	}
+	mapped, idx, err := ipsearch.NewIPSearchWithMappedIndex(writeMappedIndex(b, search))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer idx.Close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mapped.Search(benchIPs[i%len(benchIPs)])
+	}
+}
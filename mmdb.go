@@ -0,0 +1,480 @@
+package ipsearch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// mmdbMetadataMarker starts the metadata section at the end of an MMDB file.
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// The data types of the MMDB data section.
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// mmdbMaxDepth limits the nesting of the data, to stop the pointer loops
+// of a corrupted file.
+const mmdbMaxDepth = 32
+
+// ErrBadMMDB is returned when an MMDB file is corrupted or unsupported.
+var ErrBadMMDB = errors.New("bad MMDB file")
+
+// MMDBMetadata is the metadata of an MMDB file.
+type MMDBMetadata struct {
+	NodeCount                uint32
+	RecordSize               uint16
+	IPVersion                uint16
+	DatabaseType             string
+	Languages                []string
+	Description              map[string]string
+	BinaryFormatMajorVersion uint16
+	BinaryFormatMinorVersion uint16
+	BuildEpoch               uint64
+}
+
+// NewIPSearchWithMMDB creates a new IPSearch struct from an MMDB file, see
+// ReadMMDB, the IPSearch cannot be reloaded.
+func NewIPSearchWithMMDB(path string) (*IPSearch, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ipRanges, _, err := ReadMMDB(data)
+	if err != nil {
+		return nil, err
+	}
+	return newIPSearch(newContainer(ipRanges, MapListBackend), nil), nil
+}
+
+// NewIPSearchFromMMDB creates a new IPSearch struct from a reader of an MMDB
+// file, see NewIPSearchWithMMDB.
+func NewIPSearchFromMMDB(r io.Reader) (*IPSearch, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ipRanges, _, err := ReadMMDB(data)
+	if err != nil {
+		return nil, err
+	}
+	return newIPSearch(newContainer(ipRanges, MapListBackend), nil), nil
+}
+
+// ReadMMDB flattens the search tree of an MMDB file into the IP ranges, one
+// for each network of the tree, in the order of the addresses.
+//
+// The ranges are the MMDB type with the CIDR of the network, the country is
+// taken from "country.iso_code", "registered_country.iso_code" or a string
+// "country" or "country_code", the ASN and the organization are taken from
+// "autonomous_system_number" and "autonomous_system_organization", and the
+// strings of the "metadata" map, written by WriteMMDB, are the metadata. The
+// IPv4 networks of an IPv6 database are loaded once, the aliases of them (the
+// IPv4-mapped and the 6to4 networks) are skipped.
+func ReadMMDB(data []byte) ([]*IPRange, *MMDBMetadata, error) {
+	metaStart := bytes.LastIndex(data, mmdbMetadataMarker)
+	if metaStart < 0 {
+		return nil, nil, fmt.Errorf("%w: metadata not found", ErrBadMMDB)
+	}
+	metaValue, _, err := (&mmdbDecoder{data: data[metaStart+len(mmdbMetadataMarker):]}).decode(0, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := newMMDBMetadata(metaValue)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	treeSize := int(meta.NodeCount) * int(meta.RecordSize) / 4
+	if treeSize+16 > metaStart {
+		return nil, nil, fmt.Errorf("%w: search tree out of range", ErrBadMMDB)
+	}
+	t := &mmdbTree{
+		tree:       data[:treeSize],
+		nodeCount:  uint(meta.NodeCount),
+		recordSize: int(meta.RecordSize),
+		decoder:    &mmdbDecoder{data: data[treeSize+16 : metaStart]},
+		records:    make(map[uint]*IPRange),
+	}
+	ipRanges, err := t.flatten(meta.IPVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ipRanges, meta, nil
+}
+
+func newMMDBMetadata(v any) (*MMDBMetadata, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrBadMMDB)
+	}
+	uintOf := func(key string) uint64 {
+		n, _ := m[key].(uint64)
+		return n
+	}
+	meta := &MMDBMetadata{
+		NodeCount:                uint32(uintOf("node_count")),
+		RecordSize:               uint16(uintOf("record_size")),
+		IPVersion:                uint16(uintOf("ip_version")),
+		BinaryFormatMajorVersion: uint16(uintOf("binary_format_major_version")),
+		BinaryFormatMinorVersion: uint16(uintOf("binary_format_minor_version")),
+		BuildEpoch:               uintOf("build_epoch"),
+		Description:              make(map[string]string),
+	}
+	meta.DatabaseType, _ = m["database_type"].(string)
+	languages, _ := m["languages"].([]any)
+	for _, lang := range languages {
+		if s, ok := lang.(string); ok {
+			meta.Languages = append(meta.Languages, s)
+		}
+	}
+	description, _ := m["description"].(map[string]any)
+	for lang, desc := range description {
+		if s, ok := desc.(string); ok {
+			meta.Description[lang] = s
+		}
+	}
+
+	switch {
+	case meta.BinaryFormatMajorVersion != 2:
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrBadMMDB, meta.BinaryFormatMajorVersion)
+	case meta.RecordSize != 24 && meta.RecordSize != 28 && meta.RecordSize != 32:
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrBadMMDB, meta.RecordSize)
+	case meta.IPVersion != 4 && meta.IPVersion != 6:
+		return nil, fmt.Errorf("%w: unsupported IP version %d", ErrBadMMDB, meta.IPVersion)
+	}
+	return meta, nil
+}
+
+// mmdbTree is the search tree of an MMDB file.
+type mmdbTree struct {
+	tree       []byte
+	nodeCount  uint
+	recordSize int
+	decoder    *mmdbDecoder
+	records    map[uint]*IPRange // the decoded data by the offsets
+}
+
+// record returns the left (bit 0) or the right (bit 1) record of a node.
+func (t *mmdbTree) record(node uint, bit int) uint {
+	switch t.recordSize {
+	case 24:
+		b := t.tree[node*6+uint(bit)*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := t.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	}
+	return uint(binary.BigEndian.Uint32(t.tree[node*8+uint(bit)*4:]))
+}
+
+func (t *mmdbTree) flatten(ipVersion uint16) ([]*IPRange, error) {
+	type entry struct {
+		node  uint
+		ip    Uint128
+		depth int
+	}
+
+	// an IPv4 database is walked as the IPv4-mapped subtree of the 128 bits
+	root := entry{}
+	ipv4Start := t.nodeCount
+	if ipVersion == 4 {
+		root = entry{ip: ipv4To128(0), depth: 96}
+	} else {
+		ipv4Start = 0
+		for i := 0; i < 96 && ipv4Start < t.nodeCount; i++ {
+			ipv4Start = t.record(ipv4Start, 0)
+		}
+	}
+
+	var ipRanges []*IPRange
+	stack := []entry{root}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		switch {
+		case e.node == t.nodeCount:
+			continue // empty
+		case e.node > t.nodeCount:
+			ipRange, err := t.dataRange(e.node, e.ip, e.depth, ipVersion)
+			if err != nil {
+				return nil, err
+			}
+			ipRanges = append(ipRanges, ipRange)
+			continue
+		case e.depth >= 128:
+			return nil, fmt.Errorf("%w: search tree too deep", ErrBadMMDB)
+		case e.node == ipv4Start && ipVersion == 6 && (e.depth != 96 || e.ip != Uint128{}):
+			continue // an alias of the IPv4 networks
+		}
+
+		// push the right one first to walk the left one first
+		right := e.ip.or(Uint128{}.setBit(e.depth))
+		stack = append(stack,
+			entry{t.record(e.node, 1), right, e.depth + 1},
+			entry{t.record(e.node, 0), e.ip, e.depth + 1})
+	}
+	return ipRanges, nil
+}
+
+// dataRange creates the IP range of a network pointing to the data.
+func (t *mmdbTree) dataRange(pointer uint, ip Uint128, depth int, ipVersion uint16) (*IPRange, error) {
+	off := pointer - t.nodeCount - 16
+	data, ok := t.records[off]
+	if !ok {
+		v, _, err := t.decoder.decode(int(off), 0)
+		if err != nil {
+			return nil, err
+		}
+		data = mmdbFields(v)
+		t.records[off] = data
+	}
+
+	// the IPv4 networks of an IPv6 database are in ::/96
+	if ipVersion == 6 && depth >= 96 && ip.Hi == 0 && ip.Lo>>32 == 0 {
+		ip = ipv4To128(ip.Uint32())
+	}
+	end := ip.or(hostMask(depth))
+	var cidr string
+	if ip.IsIPv4() {
+		cidr = fmt.Sprintf("%s/%d", ipToStr(ip), depth-96)
+	} else {
+		cidr = fmt.Sprintf("%s/%d", ipToStr(ip), depth)
+	}
+	return &IPRange{
+		rangeType: MMDB,
+		bucket:    bucketOf(ip),
+		start:     ip,
+		end:       end,
+		cidr:      cidr,
+		country:   data.country,
+		asn:       data.asn,
+		org:       data.org,
+		meta:      data.meta,
+	}, nil
+}
+
+// setBit returns u with the i-th bit set, counting from the most significant bit.
+func (u Uint128) setBit(i int) Uint128 {
+	if i < 64 {
+		u.Hi |= 1 << (63 - i)
+	} else {
+		u.Lo |= 1 << (127 - i)
+	}
+	return u
+}
+
+// mmdbFields takes the fields of the IP range from the decoded data.
+func mmdbFields(v any) *IPRange {
+	ipRange := &IPRange{}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return ipRange
+	}
+	isoCode := func(key string) string {
+		if country, ok := m[key].(map[string]any); ok {
+			code, _ := country["iso_code"].(string)
+			return code
+		}
+		return ""
+	}
+	switch country := m["country"].(type) {
+	case string:
+		ipRange.country = country
+	default:
+		ipRange.country = isoCode("country")
+	}
+	if ipRange.country == "" {
+		ipRange.country = isoCode("registered_country")
+	}
+	if ipRange.country == "" {
+		ipRange.country, _ = m["country_code"].(string)
+	}
+	if asn, ok := m["autonomous_system_number"].(uint64); ok {
+		ipRange.asn = uint32(asn)
+	}
+	ipRange.org, _ = m["autonomous_system_organization"].(string)
+	if meta, ok := m["metadata"].(map[string]any); ok {
+		for k, v := range meta {
+			if s, ok := v.(string); ok && s != "" {
+				if ipRange.meta == nil {
+					ipRange.meta = make(metadata)
+				}
+				ipRange.meta[k] = s
+			}
+		}
+	}
+	return ipRange
+}
+
+// mmdbDecoder decodes the data section of an MMDB file. The maps are decoded
+// as map[string]any, the arrays as []any, the unsigned integers as uint64,
+// the uint128 as Uint128, the int32 as int32, and the floats as float64.
+type mmdbDecoder struct {
+	data []byte
+}
+
+// decode decodes the value at an offset, and returns the offset after it.
+func (d *mmdbDecoder) decode(off, depth int) (any, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, fmt.Errorf("%w: data too deep", ErrBadMMDB)
+	}
+	typ, size, off, err := d.control(off)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == mmdbPointer {
+		v, _, err := d.decode(size, depth+1)
+		return v, off, err
+	}
+	if typ != mmdbMap && typ != mmdbArray && typ != mmdbBool && off+size > len(d.data) {
+		return nil, 0, fmt.Errorf("%w: data out of range", ErrBadMMDB)
+	}
+
+	switch typ {
+	case mmdbString:
+		return string(d.data[off : off+size]), off + size, nil
+	case mmdbBytes:
+		return append([]byte(nil), d.data[off:off+size]...), off + size, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w: bad double size %d", ErrBadMMDB, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(d.data[off:])), off + size, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w: bad float size %d", ErrBadMMDB, size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(d.data[off:]))), off + size, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		maxSize := map[int]int{mmdbUint16: 2, mmdbUint32: 4, mmdbUint64: 8, mmdbInt32: 4}[typ]
+		if size > maxSize {
+			return nil, 0, fmt.Errorf("%w: bad integer size %d", ErrBadMMDB, size)
+		}
+		var n uint64
+		for _, b := range d.data[off : off+size] {
+			n = n<<8 | uint64(b)
+		}
+		if typ == mmdbInt32 {
+			return int32(n), off + size, nil
+		}
+		return n, off + size, nil
+	case mmdbUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("%w: bad integer size %d", ErrBadMMDB, size)
+		}
+		var n Uint128
+		for _, b := range d.data[off : off+size] {
+			n = Uint128{n.Hi<<8 | n.Lo>>56, n.Lo<<8 | uint64(b)}
+		}
+		return n, off + size, nil
+	case mmdbBool:
+		if size > 1 {
+			return nil, 0, fmt.Errorf("%w: bad boolean %d", ErrBadMMDB, size)
+		}
+		return size == 1, off, nil
+	case mmdbMap:
+		m := make(map[string]any)
+		for i := 0; i < size; i++ {
+			key, next, err := d.decode(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map key is not a string", ErrBadMMDB)
+			}
+			if m[k], off, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, off, nil
+	case mmdbArray:
+		var a []any
+		for i := 0; i < size; i++ {
+			var v any
+			if v, off, err = d.decode(off, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, off, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unsupported data type %d", ErrBadMMDB, typ)
+}
+
+// control decodes the control byte(s) at an offset, and returns the type, the
+// size (the target offset of a pointer) and the offset of the payload.
+func (d *mmdbDecoder) control(off int) (typ, size, next int, err error) {
+	bad := fmt.Errorf("%w: data out of range", ErrBadMMDB)
+	if off < 0 || off >= len(d.data) {
+		return 0, 0, 0, bad
+	}
+	ctrl := d.data[off]
+	off++
+	typ = int(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		n := int(ctrl>>3&3) + 1
+		if off+n > len(d.data) {
+			return 0, 0, 0, bad
+		}
+		ptr := 0
+		if n < 4 {
+			ptr = int(ctrl & 7)
+		}
+		for _, b := range d.data[off : off+n] {
+			ptr = ptr<<8 | int(b)
+		}
+		ptr += [...]int{0, 0, 2048, 526336, 0}[n]
+		return typ, ptr, off + n, nil
+	}
+
+	if typ == mmdbExtended {
+		if off >= len(d.data) {
+			return 0, 0, 0, bad
+		}
+		typ = 7 + int(d.data[off])
+		off++
+	}
+
+	size = int(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if off+n > len(d.data) {
+			return 0, 0, 0, bad
+		}
+		extra := 0
+		for _, b := range d.data[off : off+n] {
+			extra = extra<<8 | int(b)
+		}
+		size = [...]int{0, 29, 285, 65821}[n] + extra
+		off += n
+	}
+	return typ, size, off, nil
+}
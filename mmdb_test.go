@@ -0,0 +1,330 @@
+package ipsearch_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+// mmdbPtr is a pointer to an offset of the data section.
+type mmdbPtr int
+
+// mmdbBuilder builds small MMDB files for the tests.
+type mmdbBuilder struct {
+	ipVersion  int
+	recordSize int
+	// the records of the nodes: a node index, -1 for empty, or -2-offset
+	// for the data at the offset
+	nodes [][2]int
+	data  []byte
+}
+
+func newMMDBBuilder(ipVersion, recordSize int) *mmdbBuilder {
+	return &mmdbBuilder{ipVersion: ipVersion, recordSize: recordSize, nodes: [][2]int{{-1, -1}}}
+}
+
+// addData encodes a value to the data section and returns the offset.
+func (b *mmdbBuilder) addData(v any) int {
+	off := len(b.data)
+	b.data = append(b.data, mmdbEncode(v)...)
+	return off
+}
+
+// insert sets the record of a prefix to a value, creating the nodes.
+func (b *mmdbBuilder) insert(prefix string, value int) {
+	p := netip.MustParsePrefix(prefix)
+	bits, ip := p.Bits(), p.Addr().AsSlice()
+	if b.ipVersion == 6 && p.Addr().Is4() {
+		bits, ip = bits+96, append(make([]byte, 12), ip...)
+	}
+	node := 0
+	for i := 0; i < bits; i++ {
+		bit := int(ip[i/8]>>(7-i%8)) & 1
+		if i == bits-1 {
+			b.nodes[node][bit] = value
+			return
+		}
+		if b.nodes[node][bit] < 0 {
+			b.nodes = append(b.nodes, [2]int{-1, -1})
+			b.nodes[node][bit] = len(b.nodes) - 1
+		}
+		node = b.nodes[node][bit]
+	}
+}
+
+func (b *mmdbBuilder) insertData(prefix string, off int) {
+	b.insert(prefix, -2-off)
+}
+
+// alias points a prefix to the IPv4 subtree of an IPv6 database.
+func (b *mmdbBuilder) alias(prefix string) {
+	node := 0
+	for i := 0; i < 96; i++ {
+		node = b.nodes[node][0]
+	}
+	b.insert(prefix, node)
+}
+
+func (b *mmdbBuilder) build(meta map[string]any) []byte {
+	nodeCount := len(b.nodes)
+	record := func(v int) uint32 {
+		switch {
+		case v == -1:
+			return uint32(nodeCount)
+		case v < -1:
+			return uint32(nodeCount + 16 - 2 - v)
+		}
+		return uint32(v)
+	}
+
+	var tree []byte
+	for _, n := range b.nodes {
+		left, right := record(n[0]), record(n[1])
+		switch b.recordSize {
+		case 24:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left),
+				byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			tree = append(tree, byte(left>>16), byte(left>>8), byte(left),
+				byte(left>>24<<4|right>>24), byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(tree, left)
+			tree = binary.BigEndian.AppendUint32(tree, right)
+		}
+	}
+
+	meta["node_count"] = uint32(nodeCount)
+	meta["record_size"] = uint16(b.recordSize)
+	meta["ip_version"] = uint16(b.ipVersion)
+	meta["binary_format_major_version"] = uint16(2)
+	meta["binary_format_minor_version"] = uint16(0)
+
+	file := append(tree, make([]byte, 16)...)
+	file = append(file, b.data...)
+	file = append(file, "\xAB\xCD\xEFMaxMind.com"...)
+	return append(file, mmdbEncode(meta)...)
+}
+
+func mmdbControl(typ, size int) []byte {
+	var ctrl []byte
+	switch {
+	case size < 29:
+		ctrl = []byte{byte(size)}
+	case size < 285:
+		ctrl = []byte{29, byte(size - 29)}
+	default:
+		size -= 285
+		ctrl = []byte{30, byte(size >> 8), byte(size)}
+	}
+	if typ <= 7 {
+		ctrl[0] |= byte(typ << 5)
+		return ctrl
+	}
+	return append([]byte{ctrl[0]}, append([]byte{byte(typ - 7)}, ctrl[1:]...)...)
+}
+
+func mmdbEncodeUint(typ int, n uint64) []byte {
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append(mmdbControl(typ, len(b)), b...)
+}
+
+func mmdbEncode(v any) []byte {
+	switch v := v.(type) {
+	case string:
+		return append(mmdbControl(2, len(v)), v...)
+	case uint16:
+		return mmdbEncodeUint(5, uint64(v))
+	case uint32:
+		return mmdbEncodeUint(6, uint64(v))
+	case uint64:
+		return mmdbEncodeUint(9, v)
+	case bool:
+		if v {
+			return mmdbControl(14, 1)
+		}
+		return mmdbControl(14, 0)
+	case float64:
+		return binary.BigEndian.AppendUint64(mmdbControl(3, 8), math.Float64bits(v))
+	case mmdbPtr:
+		return []byte{1<<5 | byte(v>>8&7), byte(v)}
+	case []any:
+		b := mmdbControl(11, len(v))
+		for _, e := range v {
+			b = append(b, mmdbEncode(e)...)
+		}
+		return b
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := mmdbControl(7, len(v))
+		for _, k := range keys {
+			b = append(b, mmdbEncode(k)...)
+			b = append(b, mmdbEncode(v[k])...)
+		}
+		return b
+	}
+	panic("unsupported type")
+}
+
+func buildTestMMDB(ipVersion, recordSize int) []byte {
+	b := newMMDBBuilder(ipVersion, recordSize)
+	// the key "country" is shared through a pointer
+	countryKey := b.addData("country")
+	cn := b.addData(map[string]any{"country": map[string]any{"iso_code": "CN", "names": map[string]any{"en": "China"}}})
+	us := len(b.data)
+	b.data = append(b.data, mmdbControl(7, 4)...)
+	b.data = append(b.data, mmdbEncode(mmdbPtr(countryKey))...)
+	b.data = append(b.data, mmdbEncode(map[string]any{"iso_code": "US"})...)
+	b.data = append(b.data, mmdbEncode("autonomous_system_number")...)
+	b.data = append(b.data, mmdbEncode(uint32(15169))...)
+	b.data = append(b.data, mmdbEncode("autonomous_system_organization")...)
+	b.data = append(b.data, mmdbEncode("GOOGLE")...)
+	b.data = append(b.data, mmdbEncode("is_anycast")...)
+	b.data = append(b.data, mmdbEncode(true)...)
+
+	b.insertData("1.0.0.0/24", cn)
+	b.insertData("1.0.1.0/24", cn)
+	b.insertData("8.8.8.0/24", us)
+	if ipVersion == 6 {
+		nl := b.addData(map[string]any{
+			"registered_country": map[string]any{"iso_code": "NL"},
+			"location":           map[string]any{"latitude": 52.3824, "accuracy_radius": uint16(100)},
+			"subdivisions":       []any{map[string]any{"iso_code": "NH"}},
+			"geoname_id":         uint64(2750405),
+		})
+		b.insertData("2001:db8::/32", nl)
+		b.alias("::ffff:0:0/96")
+		b.alias("2002::/16")
+	}
+	return b.build(map[string]any{
+		"database_type": "Test-Country",
+		"languages":     []any{"en"},
+		"description":   map[string]any{"en": "the test database"},
+		"build_epoch":   uint64(1681084800),
+	})
+}
+
+func TestMMDB(t *testing.T) {
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			data := buildTestMMDB(ipVersion, recordSize)
+			ipRanges, meta, err := ipsearch.ReadMMDB(data)
+			assert.Nil(t, err, recordSize)
+			assert.Equal(t, "Test-Country", meta.DatabaseType)
+			assert.Equal(t, []string{"en"}, meta.Languages)
+			assert.Equal(t, "the test database", meta.Description["en"])
+			assert.Equal(t, uint16(recordSize), meta.RecordSize)
+
+			// the aliases are skipped
+			if ipVersion == 6 {
+				assert.Equal(t, 4, len(ipRanges))
+			} else {
+				assert.Equal(t, 3, len(ipRanges))
+			}
+
+			search, err := ipsearch.NewIPSearchFromMMDB(bytes.NewReader(data))
+			assert.Nil(t, err)
+			ip := search.Search("1.0.0.5")
+			assert.NotNil(t, ip)
+			assert.Equal(t, ipsearch.MMDB, ip.Type())
+			assert.Equal(t, "CN", ip.Country())
+			assert.Equal(t, "1.0.0.0/24", ip.CIDR())
+			assert.Equal(t, "1.0.1.0/24", search.Search("::ffff:1.0.1.255").CIDR())
+
+			ip = search.Search("8.8.8.8")
+			assert.NotNil(t, ip)
+			assert.Equal(t, "US", ip.Country())
+			assert.Equal(t, uint32(15169), ip.ASN())
+			assert.Equal(t, "GOOGLE", ip.Organization())
+
+			assert.Nil(t, search.Search("1.0.2.1"))
+			assert.Nil(t, search.Search("2002:100::1"))
+			if ipVersion == 6 {
+				ip = search.Search("2001:db8::1")
+				assert.NotNil(t, ip)
+				assert.Equal(t, "NL", ip.Country())
+				assert.Equal(t, "2001:db8::/32", ip.CIDR())
+			}
+		}
+	}
+}
+
+func TestMMDBFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	assert.Nil(t, os.WriteFile(path, buildTestMMDB(6, 28), 0o644))
+	search, err := ipsearch.NewIPSearchWithMMDB(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "US", search.Search("8.8.8.8").Country())
+
+	_, err = search.Reload([]string{"1.0.0.0/24"})
+	assert.True(t, errors.Is(err, ipsearch.ErrReloadNotSupported))
+
+	// the ASN survives the snapshot and the mapped index
+	loaded, err := ipsearch.LoadSnapshot(bytes.NewReader(snapshot(t, search)))
+	assert.Nil(t, err)
+	assert.Equal(t, search.Search("8.8.8.8"), loaded.Search("8.8.8.8"))
+	mapped, idx, err := ipsearch.NewIPSearchWithMappedIndex(writeMappedIndex(t, search))
+	assert.Nil(t, err)
+	defer idx.Close()
+	assert.Equal(t, search.Search("8.8.8.8"), mapped.Search("8.8.8.8"))
+
+	_, err = ipsearch.NewIPSearchWithMMDB("not-exist-file")
+	assert.NotNil(t, err)
+
+	// the generic constructors load the MMDB file, or refuse the lines
+	generic, err := ipsearch.NewIPSearchWithFile(path, ipsearch.MMDB)
+	assert.Nil(t, err)
+	assert.Equal(t, search.Len(), generic.Len())
+	assert.Equal(t, search.Search("8.8.8.8"), generic.Search("8.8.8.8"))
+
+	lines, err := ipsearch.ReadFile(path)
+	assert.Nil(t, err)
+	_, err = ipsearch.NewIPSearchStrict(lines, ipsearch.MMDB)
+	assert.True(t, errors.Is(err, ipsearch.ErrInvalidRangeType))
+	_, _, err = ipsearch.NewIPSearchWithFileOptions(path, ipsearch.MMDB, nil)
+	assert.True(t, errors.Is(err, ipsearch.ErrInvalidRangeType))
+	_, err = ipsearch.NewIPSearchFromURLContext(context.Background(), "http://127.0.0.1:0/test.mmdb", ipsearch.MMDB, nil)
+	assert.True(t, errors.Is(err, ipsearch.ErrInvalidRangeType))
+	empty := ipsearch.NewIPSearch(lines, ipsearch.MMDB)
+	assert.Zero(t, empty.Len())
+	_, err = empty.Reload(lines)
+	assert.True(t, errors.Is(err, ipsearch.ErrInvalidRangeType))
+}
+
+func TestMMDBCorrupted(t *testing.T) {
+	data := buildTestMMDB(6, 24)
+	marker := bytes.LastIndex(data, []byte("\xAB\xCD\xEFMaxMind.com"))
+
+	badMeta := newMMDBBuilder(6, 24)
+	badMeta.insertData("1.0.0.0/24", badMeta.addData("CN"))
+	badRecordSize := badMeta.build(map[string]any{})
+	badRecordSize = bytes.Replace(badRecordSize, append(mmdbEncode("record_size"), mmdbEncode(uint16(24))...),
+		append(mmdbEncode("record_size"), mmdbEncode(uint16(20))...), 1)
+
+	for name, bad := range map[string][]byte{
+		"empty":       {},
+		"no metadata": data[:marker],
+		"truncated":   append(append([]byte{}, data[:marker/2]...), data[marker:]...),
+		"record size": badRecordSize,
+	} {
+		_, _, err := ipsearch.ReadMMDB(bad)
+		assert.True(t, errors.Is(err, ipsearch.ErrBadMMDB), name)
+	}
+}
@@ -0,0 +1,434 @@
+package ipsearch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// MMDBOptions configures the MMDB file written by WriteMMDB.
+type MMDBOptions struct {
+	// DatabaseType is the type in the metadata, "ipsearch" by default.
+	DatabaseType string
+	// Description is the description in the metadata by the languages.
+	Description map[string]string
+	// Languages is the languages in the metadata.
+	Languages []string
+	// IPVersion is 6 (the default) or 4, an IPv4 database cannot contain
+	// the IPv6 ranges. The IPv4 networks of an IPv6 database are aliased to
+	// ::ffff:0:0/96 and 2002::/16, like the MaxMind databases.
+	IPVersion int
+	// BuildTime is the build time in the metadata, now by default.
+	BuildTime time.Time
+	// Record builds the data record of an IP range, by default it has
+	// "country.iso_code", "autonomous_system_number",
+	// "autonomous_system_organization" and the "metadata" map of strings if
+	// the range has them.
+	Record func(ipRange *IPRange) map[string]any
+	// Tags are the custom fields added to every record.
+	Tags map[string]any
+}
+
+// WriteMMDB writes the ranges of the IPSearch as an MMDB file, which can be
+// read by ReadMMDB and the MaxMind DB readers (e.g. libmaxminddb).
+func (s *IPSearch) WriteMMDB(w io.Writer, opts *MMDBOptions) error {
+	return writeMMDB(w, s.Container(), opts)
+}
+
+// WriteMMDB writes the ranges of the list as an MMDB file, see IPSearch.WriteMMDB.
+func (list *IPRangeList) WriteMMDB(w io.Writer, opts *MMDBOptions) error {
+	return writeMMDB(w, list, opts)
+}
+
+// defaultMMDBRecord is the default data record of an IP range.
+func defaultMMDBRecord(ipRange *IPRange) map[string]any {
+	record := make(map[string]any)
+	if ipRange.country != "" {
+		record["country"] = map[string]any{"iso_code": ipRange.country}
+	}
+	if ipRange.asn != 0 {
+		record["autonomous_system_number"] = ipRange.asn
+	}
+	if ipRange.org != "" {
+		record["autonomous_system_organization"] = ipRange.org
+	}
+	if len(ipRange.meta) > 0 {
+		record["metadata"] = map[string]string(ipRange.meta)
+	}
+	return record
+}
+
+func writeMMDB(w io.Writer, c Container, opts *MMDBOptions) error {
+	o := MMDBOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.DatabaseType == "" {
+		o.DatabaseType = "ipsearch"
+	}
+	if o.IPVersion == 0 {
+		o.IPVersion = 6
+	}
+	if o.IPVersion != 4 && o.IPVersion != 6 {
+		return fmt.Errorf("%w: unsupported IP version %d", ErrBadMMDB, o.IPVersion)
+	}
+	if o.BuildTime.IsZero() {
+		o.BuildTime = time.Now()
+	}
+	if o.Record == nil {
+		o.Record = defaultMMDBRecord
+	}
+
+	v4, v6 := splitSorted(c)
+	if o.IPVersion == 4 && len(v6) > 0 {
+		return fmt.Errorf("%w: IPv6 ranges in an IPv4 database", ErrBadMMDB)
+	}
+
+	// the records are deduplicated by the encoded bytes
+	var data []byte
+	var dataOffsets []int
+	dataIndex := make(map[string]int)
+	type network struct {
+		prefix
+		value int
+	}
+	var networks []network
+	for _, ipRange := range append(v4, v6...) {
+		record := o.Record(ipRange)
+		for k, v := range o.Tags {
+			record[k] = v
+		}
+		encoded, err := appendMMDBValue(nil, record)
+		if err != nil {
+			return err
+		}
+		i, ok := dataIndex[string(encoded)]
+		if !ok {
+			i = len(dataOffsets)
+			dataIndex[string(encoded)] = i
+			dataOffsets = append(dataOffsets, len(data))
+			data = append(data, encoded...)
+		}
+		for _, p := range rangeToPrefixes(ipRange.start, ipRange.end) {
+			networks = append(networks, network{p, mmdbData(i)})
+		}
+	}
+	// the more specific networks are inserted later to win
+	sort.SliceStable(networks, func(i, j int) bool { return networks[i].bits < networks[j].bits })
+
+	t := &mmdbWriterTree{nodes: [][2]int{{mmdbEmpty, mmdbEmpty}}}
+	from := 0
+	if o.IPVersion == 4 {
+		from = 96
+	}
+	for _, n := range networks {
+		ip := n.ip
+		if o.IPVersion == 6 && ip.IsIPv4() {
+			// the IPv4 networks are in ::/96 of an IPv6 database
+			ip = Uint128{0, uint64(ip.Uint32())}
+		}
+		t.insert(ip, from, n.bits, n.value)
+	}
+	t.collapse(0, true)
+	if o.IPVersion == 6 {
+		t.aliasIPv4()
+	}
+	nodes := t.renumber()
+
+	nodeCount := len(nodes)
+	maxRecord := nodeCount + 16 + len(data)
+	recordSize := 24
+	switch {
+	case maxRecord >= 1<<32:
+		return fmt.Errorf("%w: database too large", ErrBadMMDB)
+	case maxRecord >= 1<<28:
+		recordSize = 32
+	case maxRecord >= 1<<24:
+		recordSize = 28
+	}
+	record := func(v int) uint32 {
+		switch {
+		case v == mmdbEmpty:
+			return uint32(nodeCount)
+		case v < mmdbEmpty:
+			return uint32(nodeCount + 16 + dataOffsets[mmdbData(v)])
+		}
+		return uint32(v)
+	}
+
+	buf := make([]byte, 0, nodeCount*recordSize/4+16+len(data)+256)
+	for _, n := range nodes {
+		left, right := record(n[0]), record(n[1])
+		switch recordSize {
+		case 24:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left),
+				byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left),
+				byte(left>>24<<4|right>>24&0x0F), byte(right>>16), byte(right>>8), byte(right))
+		default:
+			buf = binary.BigEndian.AppendUint32(buf, left)
+			buf = binary.BigEndian.AppendUint32(buf, right)
+		}
+	}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+
+	languages := make([]any, len(o.Languages))
+	for i, lang := range o.Languages {
+		languages[i] = lang
+	}
+	description := make(map[string]any, len(o.Description))
+	for lang, desc := range o.Description {
+		description[lang] = desc
+	}
+	buf = append(buf, mmdbMetadataMarker...)
+	buf, err := appendMMDBValue(buf, map[string]any{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(o.IPVersion),
+		"database_type":               o.DatabaseType,
+		"languages":                   languages,
+		"description":                 description,
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(o.BuildTime.Unix()),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// The records of the writer tree are the node indexes, mmdbEmpty, or the
+// data indexes encoded by mmdbData.
+const mmdbEmpty = -1
+
+// mmdbData converts a data index to a record and back.
+func mmdbData(i int) int {
+	return -2 - i
+}
+
+type mmdbWriterTree struct {
+	nodes [][2]int
+}
+
+// insert sets the records of the prefix ip/to to the value, the tree starts
+// at the bit from.
+func (t *mmdbWriterTree) insert(ip Uint128, from, to, value int) {
+	if to == from {
+		// the root cannot hold a value, set both of the halves
+		t.insert(ip, from, from+1, value)
+		t.insert(ip.setBit(from), from, from+1, value)
+		return
+	}
+	node := 0
+	for i := from; ; i++ {
+		bit := ip.bit(i)
+		if i == to-1 {
+			t.nodes[node][bit] = value
+			return
+		}
+		next := t.nodes[node][bit]
+		if next < 0 {
+			// split an empty or a data record, the halves inherit it
+			t.nodes = append(t.nodes, [2]int{next, next})
+			next = len(t.nodes) - 1
+			t.nodes[node][bit] = next
+		}
+		node = next
+	}
+}
+
+// collapse merges the nodes whose halves are the same data or empty, and
+// returns the record replacing the node.
+func (t *mmdbWriterTree) collapse(node int, root bool) int {
+	if node < 0 {
+		return node
+	}
+	n := &t.nodes[node]
+	n[0] = t.collapse(n[0], false)
+	n[1] = t.collapse(n[1], false)
+	if !root && n[0] < 0 && n[0] == n[1] {
+		return n[0]
+	}
+	return node
+}
+
+// aliasIPv4 points ::ffff:0:0/96 and 2002::/16 to the IPv4 networks in ::/96
+// if they are empty.
+func (t *mmdbWriterTree) aliasIPv4() {
+	ipv4Start := 0
+	for i := 0; i < 96 && ipv4Start >= 0; i++ {
+		ipv4Start = t.nodes[ipv4Start][0]
+	}
+	if ipv4Start < 0 {
+		return
+	}
+	for _, alias := range []prefix{
+		{Uint128{0, v4MappedPrefix}, 96},
+		{Uint128{0x2002 << 48, 0}, 16},
+	} {
+		if t.isEmpty(alias.ip, alias.bits) {
+			t.insert(alias.ip, 0, alias.bits, ipv4Start)
+		}
+	}
+}
+
+// isEmpty reports whether there is no network in the prefix ip/bits.
+func (t *mmdbWriterTree) isEmpty(ip Uint128, bits int) bool {
+	node := 0
+	for i := 0; i < bits; i++ {
+		node = t.nodes[node][ip.bit(i)]
+		if node < 0 {
+			return node == mmdbEmpty
+		}
+	}
+	return false
+}
+
+// renumber returns the nodes reachable from the root, numbered in the
+// breadth-first order, the root is 0.
+func (t *mmdbWriterTree) renumber() [][2]int {
+	index := map[int]int{0: 0}
+	order := []int{0}
+	for i := 0; i < len(order); i++ {
+		for _, child := range t.nodes[order[i]] {
+			if _, ok := index[child]; child >= 0 && !ok {
+				index[child] = len(order)
+				order = append(order, child)
+			}
+		}
+	}
+	nodes := make([][2]int, len(order))
+	for i, old := range order {
+		for bit, child := range t.nodes[old] {
+			if child >= 0 {
+				child = index[child]
+			}
+			nodes[i][bit] = child
+		}
+	}
+	return nodes
+}
+
+// appendMMDBValue encodes a value in the MMDB data format.
+func appendMMDBValue(buf []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		return append(appendMMDBControl(buf, mmdbString, len(v)), v...), nil
+	case []byte:
+		return append(appendMMDBControl(buf, mmdbBytes, len(v)), v...), nil
+	case bool:
+		if v {
+			return appendMMDBControl(buf, mmdbBool, 1), nil
+		}
+		return appendMMDBControl(buf, mmdbBool, 0), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(appendMMDBControl(buf, mmdbDouble, 8), math.Float64bits(v)), nil
+	case float32:
+		return binary.BigEndian.AppendUint32(appendMMDBControl(buf, mmdbFloat, 4), math.Float32bits(v)), nil
+	case uint16:
+		return appendMMDBUint(buf, mmdbUint16, uint64(v)), nil
+	case uint32:
+		return appendMMDBUint(buf, mmdbUint32, uint64(v)), nil
+	case uint64:
+		return appendMMDBUint(buf, mmdbUint64, v), nil
+	case uint:
+		return appendMMDBUint(buf, mmdbUint64, uint64(v)), nil
+	case int32:
+		return binary.BigEndian.AppendUint32(appendMMDBControl(buf, mmdbInt32, 4), uint32(v)), nil
+	case int:
+		switch {
+		case v >= 0 && v <= math.MaxUint32:
+			return appendMMDBUint(buf, mmdbUint32, uint64(v)), nil
+		case v >= 0:
+			return appendMMDBUint(buf, mmdbUint64, uint64(v)), nil
+		case v >= math.MinInt32:
+			return binary.BigEndian.AppendUint32(appendMMDBControl(buf, mmdbInt32, 4), uint32(v)), nil
+		}
+	case Uint128:
+		b := binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, v.Hi), v.Lo)
+		b = bytes.TrimLeft(b, "\x00")
+		return append(appendMMDBControl(buf, mmdbUint128, len(b)), b...), nil
+	case []string:
+		buf = appendMMDBControl(buf, mmdbArray, len(v))
+		for _, s := range v {
+			buf = append(appendMMDBControl(buf, mmdbString, len(s)), s...)
+		}
+		return buf, nil
+	case []any:
+		buf = appendMMDBControl(buf, mmdbArray, len(v))
+		var err error
+		for _, e := range v {
+			if buf, err = appendMMDBValue(buf, e); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]string:
+		m := make(map[string]any, len(v))
+		for k, s := range v {
+			m[k] = s
+		}
+		return appendMMDBValue(buf, m)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf = appendMMDBControl(buf, mmdbMap, len(v))
+		var err error
+		for _, k := range keys {
+			buf = append(appendMMDBControl(buf, mmdbString, len(k)), k...)
+			if buf, err = appendMMDBValue(buf, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported data type %T", ErrBadMMDB, v)
+}
+
+func appendMMDBUint(buf []byte, typ int, n uint64) []byte {
+	size := 0
+	for x := n; x > 0; x >>= 8 {
+		size++
+	}
+	buf = appendMMDBControl(buf, typ, size)
+	for i := size - 1; i >= 0; i-- {
+		buf = append(buf, byte(n>>(8*i)))
+	}
+	return buf
+}
+
+// appendMMDBControl encodes the control byte(s) of a type and a size.
+func appendMMDBControl(buf []byte, typ, size int) []byte {
+	var ext []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		ext = []byte{byte(size - 29)}
+		size = 29
+	case size < 65821:
+		size -= 285
+		ext = []byte{byte(size >> 8), byte(size)}
+		size = 30
+	default:
+		size -= 65821
+		ext = []byte{byte(size >> 16), byte(size >> 8), byte(size)}
+		size = 31
+	}
+	if typ < mmdbInt32 {
+		buf = append(buf, byte(typ<<5|size))
+	} else {
+		buf = append(buf, byte(size), byte(typ-7))
+	}
+	return append(buf, ext...)
+}
@@ -0,0 +1,122 @@
+package ipsearch_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+func writeMMDB(t *testing.T, search *ipsearch.IPSearch, opts *ipsearch.MMDBOptions) []byte {
+	var buf bytes.Buffer
+	assert.Nil(t, search.WriteMMDB(&buf, opts))
+	return buf.Bytes()
+}
+
+// assertSameSearch checks the start and the end of every range of the
+// search resolve to the same country in the other search.
+func assertSameSearch(t *testing.T, search, other *ipsearch.IPSearch) {
+	search.Container().Walk(func(ip *ipsearch.IPRange) bool {
+		for _, addr := range strings.Split(ip.Range(), " - ") {
+			found := other.Search(addr)
+			if assert.NotNil(t, found, addr) {
+				assert.Equal(t, ip.Country(), found.Country(), addr)
+			}
+		}
+		return true
+	})
+}
+
+func TestWriteMMDB(t *testing.T) {
+	search, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	assert.Nil(t, err)
+	for _, ipVersion := range []int{4, 6} {
+		data := writeMMDB(t, search, &ipsearch.MMDBOptions{
+			DatabaseType: "Test-Geo",
+			Description:  map[string]string{"en": "the test database"},
+			Languages:    []string{"en"},
+			IPVersion:    ipVersion,
+			BuildTime:    time.Unix(1681084800, 0),
+		})
+		_, meta, err := ipsearch.ReadMMDB(data)
+		assert.Nil(t, err)
+		assert.Equal(t, "Test-Geo", meta.DatabaseType)
+		assert.Equal(t, uint16(ipVersion), meta.IPVersion)
+		assert.Equal(t, uint64(1681084800), meta.BuildEpoch)
+		assert.Equal(t, "the test database", meta.Description["en"])
+
+		loaded, err := ipsearch.NewIPSearchFromMMDB(bytes.NewReader(data))
+		assert.Nil(t, err)
+		assertSameSearch(t, search, loaded)
+		testGeoSearch(t, loaded)
+	}
+}
+
+func TestWriteMMDBIPv6(t *testing.T) {
+	search := ipsearch.NewIPSearch(append(geo6, geo...), ipsearch.Geo)
+	loaded, err := ipsearch.NewIPSearchFromMMDB(bytes.NewReader(writeMMDB(t, search, nil)))
+	assert.Nil(t, err)
+	assertSameSearch(t, search, loaded)
+
+	// the IPv4 aliases are not loaded as the networks
+	assert.Nil(t, loaded.Search("::ffff:0:1"))
+	assert.Nil(t, loaded.Search("2002::1"))
+
+	var buf bytes.Buffer
+	err = search.WriteMMDB(&buf, &ipsearch.MMDBOptions{IPVersion: 4})
+	assert.True(t, errors.Is(err, ipsearch.ErrBadMMDB))
+}
+
+func TestWriteMMDBRecord(t *testing.T) {
+	// the nested ranges resolve to the most specific one
+	opts := &ipsearch.LoadOptions{Backend: ipsearch.TrieBackend}
+	search, _, err := ipsearch.NewIPSearchWithOptions([]string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24"}, ipsearch.CIDR, opts)
+	assert.Nil(t, err)
+
+	data := writeMMDB(t, search, &ipsearch.MMDBOptions{
+		Record: func(ip *ipsearch.IPRange) map[string]any {
+			return map[string]any{"country": ip.CIDR()}
+		},
+		Tags: map[string]any{"list": "private", "blocked": true},
+	})
+	loaded, err := ipsearch.NewIPSearchFromMMDB(bytes.NewReader(data))
+	assert.Nil(t, err)
+	for ip, cidr := range map[string]string{
+		"10.0.0.1": "10.0.0.0/8",
+		"10.1.0.1": "10.1.0.0/16",
+		"10.1.2.1": "10.1.2.0/24",
+		"10.1.3.1": "10.1.0.0/16",
+	} {
+		assert.Equal(t, cidr, loaded.Search(ip).Country(), ip)
+	}
+	assert.Nil(t, loaded.Search("11.0.0.1"))
+
+	var buf bytes.Buffer
+	err = search.WriteMMDB(&buf, &ipsearch.MMDBOptions{Tags: map[string]any{"bad": struct{}{}}})
+	assert.True(t, errors.Is(err, ipsearch.ErrBadMMDB))
+
+	// the list writes the same database
+	list := ipsearch.NewIPRangeList([]string{"1.0.0.0/24"}, ipsearch.CIDR)
+	buf.Reset()
+	assert.Nil(t, list.WriteMMDB(&buf, nil))
+	loaded, err = ipsearch.NewIPSearchFromMMDB(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.0.0.0/24", loaded.Search("1.0.0.1").CIDR())
+}
+
+func TestWriteMMDBASN(t *testing.T) {
+	search, err := ipsearch.NewIPSearchFromMMDB(bytes.NewReader(buildTestMMDB(6, 24)))
+	assert.Nil(t, err)
+	loaded, err := ipsearch.NewIPSearchFromMMDB(bytes.NewReader(writeMMDB(t, search, nil)))
+	assert.Nil(t, err)
+	assert.Equal(t, search.Search("8.8.8.8"), loaded.Search("8.8.8.8"))
+
+	// the sibling networks of the same record are merged
+	assert.Equal(t, "1.0.0.0/23", loaded.Search("1.0.1.1").CIDR())
+	assert.Equal(t, search.Len()-1, loaded.Len())
+}
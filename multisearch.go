@@ -0,0 +1,123 @@
+package ipsearch
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync/atomic"
+)
+
+// ErrInvalidDataset is returned when a dataset of a MultiSearch has an empty
+// or a duplicate name, or no IPSearch.
+var ErrInvalidDataset = errors.New("invalid dataset")
+
+// Dataset is a named IP range list of a MultiSearch, like the China list, a
+// country Geo DB, a blocklist or a cloud provider list.
+type Dataset struct {
+	Name   string
+	Search *IPSearch
+}
+
+// Match is a range of a dataset matching an IP address.
+type Match struct {
+	Dataset string
+	Range   *IPRange
+}
+
+// MultiSearch searches several named datasets in a single lookup.
+//
+// The ranges of all of the datasets are cut into the disjoint pieces, every
+// piece has the matches of the datasets covering it, and the pieces are
+// indexed by an IPTable. The overlapping ranges of a dataset, like the
+// nested CIDR ranges of a trie, are resolved to the most specific ones, so a
+// dataset has at most one match of an address.
+//
+// It is safe for concurrent use, Refresh rebuilds the index from the
+// datasets and swaps it atomically, like IPSearch.Reload.
+type MultiSearch struct {
+	datasets []Dataset
+	table    atomic.Pointer[IPTable[[]Match]]
+}
+
+// NewMultiSearch creates a new MultiSearch of the datasets, the matches are
+// in the order of the datasets.
+func NewMultiSearch(datasets ...Dataset) (*MultiSearch, error) {
+	names := make(map[string]bool, len(datasets))
+	for _, d := range datasets {
+		if d.Name == "" || d.Search == nil || names[d.Name] {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidDataset, d.Name)
+		}
+		names[d.Name] = true
+	}
+	m := &MultiSearch{datasets: datasets}
+	m.Refresh()
+	return m, nil
+}
+
+// Refresh rebuilds the index from the current ranges of the datasets, after
+// some of them are reloaded.
+func (m *MultiSearch) Refresh() {
+	lists := make([][]*IPRange, len(m.datasets))
+	for i, d := range m.datasets {
+		lists[i] = disjointRanges(d.Search.Container())
+	}
+
+	table := NewIPTable[[]Match]()
+	sweepIPRanges(lists, func(start, end Uint128, covering []*IPRange) {
+		var matches []Match
+		for i, ip := range covering {
+			if ip != nil {
+				matches = append(matches, Match{Dataset: m.datasets[i].Name, Range: ip})
+			}
+		}
+		table.append(&IPRange{bucket: bucketOf(start), start: start, end: end}, matches)
+	})
+	table.sort()
+	m.table.Store(table)
+}
+
+// Datasets returns the names of the datasets.
+func (m *MultiSearch) Datasets() []string {
+	names := make([]string, len(m.datasets))
+	for i, d := range m.datasets {
+		names[i] = d.Name
+	}
+	return names
+}
+
+// Search returns the matches of an IPv4 or IPv6 address in all of the
+// datasets, or nil if it matches none. The matches must not be modified.
+func (m *MultiSearch) Search(ip string) []Match {
+	matches, _ := m.table.Load().Lookup(ip)
+	return matches
+}
+
+// SearchAddr returns the matches of a netip.Addr, see Search.
+func (m *MultiSearch) SearchAddr(addr netip.Addr) []Match {
+	matches, _ := m.table.Load().LookupAddr(addr)
+	return matches
+}
+
+// SearchUint32 returns the matches of an integer IPv4 address, see Search.
+func (m *MultiSearch) SearchUint32(ip uint32) []Match {
+	matches, _ := m.table.Load().LookupUint32(ip)
+	return matches
+}
+
+// Labels returns the names of the datasets matching an IPv4 or IPv6 address.
+func (m *MultiSearch) Labels(ip string) []string {
+	matches := m.Search(ip)
+	if len(matches) == 0 {
+		return nil
+	}
+	labels := make([]string, len(matches))
+	for i, match := range matches {
+		labels[i] = match.Dataset
+	}
+	return labels
+}
+
+// Len returns the number of the disjoint pieces in the index.
+func (m *MultiSearch) Len() int {
+	return m.table.Load().Len()
+}
@@ -0,0 +1,141 @@
+package ipsearch_test
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+func newTestMultiSearch(t *testing.T) (*ipsearch.MultiSearch, []ipsearch.Dataset) {
+	china, err := ipsearch.NewIPSearchWithFile(IPv4CIDRFile, ipsearch.CIDR)
+	assert.Nil(t, err)
+	geo, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	assert.Nil(t, err)
+	blocklist, err := ipsearch.NewIPSearchStrict([]string{"1.0.1.128/25", "8.8.8.8/32", "2001:db8::/32"}, ipsearch.CIDR)
+	assert.Nil(t, err)
+	cloud, err := ipsearch.NewIPSearchStrict([]string{"8.8.8.0/24", "2001:db8:1::/48"}, ipsearch.CIDR)
+	assert.Nil(t, err)
+
+	datasets := []ipsearch.Dataset{
+		{Name: "china", Search: china},
+		{Name: "geo", Search: geo},
+		{Name: "blocklist", Search: blocklist},
+		{Name: "cloud", Search: cloud},
+	}
+	m, err := ipsearch.NewMultiSearch(datasets...)
+	assert.Nil(t, err)
+	return m, datasets
+}
+
+func TestMultiSearch(t *testing.T) {
+	m, datasets := newTestMultiSearch(t)
+	assert.Equal(t, []string{"china", "geo", "blocklist", "cloud"}, m.Datasets())
+
+	assert.Equal(t, []string{"china", "geo", "blocklist"}, m.Labels("1.0.1.200"))
+	assert.Equal(t, []string{"china", "geo"}, m.Labels("1.0.1.1"))
+	assert.Equal(t, []string{"geo", "blocklist", "cloud"}, m.Labels("8.8.8.8"))
+	assert.Equal(t, []string{"geo", "cloud"}, m.Labels("8.8.8.9"))
+	assert.Equal(t, []string{"blocklist", "cloud"}, m.Labels("2001:db8:1::1"))
+	assert.Equal(t, []string{"blocklist"}, m.Labels("2001:db8:2::1"))
+	assert.Nil(t, m.Labels("2001:db9::1"))
+
+	matches := m.Search("8.8.8.8")
+	assert.Equal(t, 3, len(matches))
+	assert.Equal(t, "geo", matches[0].Dataset)
+	assert.Equal(t, "US", matches[0].Range.Country())
+	assert.Equal(t, "8.8.8.8/32", matches[1].Range.CIDR())
+	assert.Equal(t, "8.8.8.0/24", matches[2].Range.CIDR())
+	assert.Equal(t, matches, m.SearchAddr(netip.MustParseAddr("8.8.8.8")))
+	assert.Equal(t, matches, m.SearchUint32(ipsearch.IPStrToInt("8.8.8.8")))
+	assert.Nil(t, m.SearchAddr(netip.Addr{}))
+
+	// the matches are the same as searching the datasets one by one
+	for _, ip := range []string{"1.0.1.0", "1.0.1.127", "1.0.1.128", "1.0.1.255", "1.0.8.1", "114.114.114.114", "223.255.255.255"} {
+		var want []ipsearch.Match
+		for _, d := range datasets {
+			if found := d.Search.Search(ip); found != nil {
+				want = append(want, ipsearch.Match{Dataset: d.Name, Range: found})
+			}
+		}
+		assert.Equal(t, want, m.Search(ip), ip)
+	}
+
+	// the reloaded datasets are picked up by Refresh
+	n := m.Len()
+	_, err := datasets[2].Search.Reload([]string{"114.114.114.114/32"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"china", "geo", "blocklist"}, m.Labels("1.0.1.200"))
+	m.Refresh()
+	assert.Equal(t, []string{"china", "geo"}, m.Labels("1.0.1.200"))
+	assert.Equal(t, []string{"china", "geo", "blocklist"}, m.Labels("114.114.114.114"))
+	assert.NotEqual(t, n, m.Len())
+}
+
+func TestMultiSearchNested(t *testing.T) {
+	nested, _, err := ipsearch.NewIPSearchWithOptions(nestedCIDRs, ipsearch.CIDR, &ipsearch.LoadOptions{Backend: ipsearch.TrieBackend})
+	assert.Nil(t, err)
+	cloud, err := ipsearch.NewIPSearchStrict([]string{"10.1.2.0/25"}, ipsearch.CIDR)
+	assert.Nil(t, err)
+	m, err := ipsearch.NewMultiSearch(ipsearch.Dataset{Name: "nested", Search: nested}, ipsearch.Dataset{Name: "cloud", Search: cloud})
+	assert.Nil(t, err)
+
+	matches := m.Search("10.1.2.3")
+	if assert.Equal(t, 2, len(matches)) {
+		assert.Equal(t, "nested", matches[0].Dataset)
+		assert.Equal(t, "10.1.2.0/24", matches[0].Range.CIDR())
+		assert.Equal(t, "cloud", matches[1].Dataset)
+	}
+	for ip, cidr := range map[string]string{
+		"10.1.2.200":    "10.1.2.0/24",
+		"10.1.3.1":      "10.1.0.0/16",
+		"10.2.0.1":      "10.0.0.0/8",
+		"2001:db8:1::1": "2001:db8:1::/48",
+		"2001:db8:2::1": "2001:db8::/32",
+	} {
+		matches := m.Search(ip)
+		if assert.Equal(t, 1, len(matches), ip) {
+			assert.Equal(t, cidr, matches[0].Range.CIDR(), ip)
+		}
+	}
+}
+
+func TestMultiSearchInvalid(t *testing.T) {
+	search := ipsearch.NewIPSearch(cidrs, ipsearch.CIDR)
+	for name, datasets := range map[string][]ipsearch.Dataset{
+		"empty name": {{Name: "", Search: search}},
+		"no search":  {{Name: "china"}},
+		"duplicate":  {{Name: "china", Search: search}, {Name: "china", Search: search}},
+	} {
+		_, err := ipsearch.NewMultiSearch(datasets...)
+		assert.True(t, errors.Is(err, ipsearch.ErrInvalidDataset), name)
+	}
+
+	m, err := ipsearch.NewMultiSearch()
+	assert.Nil(t, err)
+	assert.Nil(t, m.Search("1.0.1.1"))
+	assert.Zero(t, m.Len())
+}
+
+func BenchmarkMultiSearch(b *testing.B) {
+	china, err := ipsearch.NewIPSearchWithFile(IPv4CIDRFile, ipsearch.CIDR)
+	if err != nil {
+		b.Fatal(err)
+	}
+	geo, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	if err != nil {
+		b.Fatal(err)
+	}
+	m, err := ipsearch.NewMultiSearch(ipsearch.Dataset{Name: "china", Search: china}, ipsearch.Dataset{Name: "geo", Search: geo})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Search("114.114.114.114")
+	}
+}
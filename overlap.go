@@ -0,0 +1,192 @@
+package ipsearch
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// OverlapPolicy decides how the overlapping ranges are resolved.
+type OverlapPolicy int
+
+const (
+	// OverlapAllow keeps the overlapping ranges as they are, the search
+	// result of an IP in the overlapped part is undefined.
+	OverlapAllow OverlapPolicy = iota
+	// OverlapReject refuses to load the overlapping ranges.
+	OverlapReject
+	// OverlapMostSpecific resolves the overlapped part to the smallest range.
+	OverlapMostSpecific
+	// OverlapFirstWins resolves the overlapped part to the range loaded first.
+	OverlapFirstWins
+	// OverlapLastWins resolves the overlapped part to the range loaded last.
+	OverlapLastWins
+)
+
+// ErrOverlap is returned by the OverlapReject policy.
+var ErrOverlap = errors.New("overlapping ranges")
+
+// Overlap is a pair of overlapping ranges, First is loaded before Second.
+type Overlap struct {
+	First  *IPRange
+	Second *IPRange
+}
+
+func (o Overlap) String() string {
+	return fmt.Sprintf("[%s] and [%s]", o.First.Range(), o.Second.Range())
+}
+
+// FindOverlaps returns the overlapping ranges, every range overlapping a
+// previous one is reported once, paired with the previous range reaching
+// the farthest.
+func FindOverlaps(ipRanges []*IPRange) []Overlap {
+	sorted := sortedByStart(ipRanges)
+	var overlaps []Overlap
+	var farthest *indexedRange
+	for i := range sorted {
+		r := &sorted[i]
+		if farthest != nil && !farthest.end.Less(r.start) {
+			if farthest.index < r.index {
+				overlaps = append(overlaps, Overlap{farthest.IPRange, r.IPRange})
+			} else {
+				overlaps = append(overlaps, Overlap{r.IPRange, farthest.IPRange})
+			}
+		}
+		if farthest == nil || farthest.end.Less(r.end) {
+			farthest = r
+		}
+	}
+	return overlaps
+}
+
+// ResolveOverlaps detects the overlapping ranges and resolves them with the
+// policy. The resolved ranges are disjoint, a range losing part of its
+// addresses is cut into the pieces it still wins, each piece keeps the CIDR
+// and the country of the original range.
+func ResolveOverlaps(ipRanges []*IPRange, policy OverlapPolicy) ([]*IPRange, []Overlap, error) {
+	overlaps := FindOverlaps(ipRanges)
+	if len(overlaps) == 0 || policy == OverlapAllow {
+		return ipRanges, overlaps, nil
+	}
+	if policy == OverlapReject {
+		return nil, overlaps, fmt.Errorf("%w: %d found, the first one is %s", ErrOverlap, len(overlaps), overlaps[0])
+	}
+
+	sorted := sortedByStart(ipRanges)
+
+	// all of the boundaries of the ranges
+	points := make([]Uint128, 0, 2*len(sorted))
+	for _, r := range sorted {
+		points = append(points, r.start)
+		if next, ok := r.end.addOne(); ok {
+			points = append(points, next)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Less(points[j]) })
+
+	// sweep the boundaries, the winner of every elementary interval is the
+	// top of the active ranges heap.
+	active := &rangeHeap{policy: policy}
+	resolved := make([]*IPRange, 0, len(ipRanges))
+	var lastWinner *indexedRange
+	next := 0
+	for i, point := range points {
+		if i > 0 && point == points[i-1] {
+			continue
+		}
+		for next < len(sorted) && sorted[next].start == point {
+			heap.Push(active, &sorted[next])
+			next++
+		}
+		for active.Len() > 0 && active.top().end.Less(point) {
+			heap.Pop(active)
+		}
+		if active.Len() == 0 {
+			continue
+		}
+
+		winner := active.top()
+		end := winner.end
+		if j := nextPoint(points, i); j < len(points) {
+			end, _ = points[j].subOne()
+		}
+
+		// coalesce with the previous piece of the same winner
+		if winner == lastWinner {
+			last := resolved[len(resolved)-1]
+			if lastNext, ok := last.end.addOne(); ok && lastNext == point {
+				last.end = end
+				continue
+			}
+		}
+		lastWinner = winner
+		piece := *winner.IPRange
+		piece.start = point
+		piece.end = end
+		piece.bucket = bucketOf(point)
+		resolved = append(resolved, &piece)
+	}
+	return resolved, overlaps, nil
+}
+
+// indexedRange is a range with its loading order.
+type indexedRange struct {
+	*IPRange
+	index int
+}
+
+func sortedByStart(ipRanges []*IPRange) []indexedRange {
+	sorted := make([]indexedRange, len(ipRanges))
+	for i, r := range ipRanges {
+		sorted[i] = indexedRange{r, i}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].start.Less(sorted[j].start)
+	})
+	return sorted
+}
+
+// nextPoint returns the index of the next distinct point.
+func nextPoint(points []Uint128, i int) int {
+	j := i + 1
+	for j < len(points) && points[j] == points[i] {
+		j++
+	}
+	return j
+}
+
+// rangeHeap is a heap of the ranges, the top is the winner of the policy.
+type rangeHeap struct {
+	policy OverlapPolicy
+	ranges []*indexedRange
+}
+
+func (h *rangeHeap) Len() int { return len(h.ranges) }
+
+func (h *rangeHeap) Less(i, j int) bool {
+	a, b := h.ranges[i], h.ranges[j]
+	switch h.policy {
+	case OverlapMostSpecific:
+		if sa, sb := a.end.sub(a.start), b.end.sub(b.start); sa != sb {
+			return sa.Less(sb)
+		}
+		return a.index < b.index
+	case OverlapLastWins:
+		return a.index > b.index
+	}
+	return a.index < b.index
+}
+
+func (h *rangeHeap) Swap(i, j int) { h.ranges[i], h.ranges[j] = h.ranges[j], h.ranges[i] }
+
+func (h *rangeHeap) Push(x any) { h.ranges = append(h.ranges, x.(*indexedRange)) }
+
+func (h *rangeHeap) Pop() any {
+	n := len(h.ranges)
+	x := h.ranges[n-1]
+	h.ranges = h.ranges[:n-1]
+	return x
+}
+
+func (h *rangeHeap) top() *indexedRange { return h.ranges[0] }
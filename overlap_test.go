@@ -0,0 +1,120 @@
+package ipsearch_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+var overlapCIDRs = []string{
+	"10.0.0.0/16",
+	"10.0.1.0/24",
+	"10.0.0.0/16",
+	"192.168.0.0/24",
+	"10.0.255.128/25",
+}
+
+func TestFindOverlaps(t *testing.T) {
+	ipRanges := ipsearch.NewIPRangeSlice(cidrs, ipsearch.CIDR)
+	assert.Empty(t, ipsearch.FindOverlaps(ipRanges))
+
+	ipRanges = ipsearch.NewIPRangeSlice(overlapCIDRs, ipsearch.CIDR)
+	overlaps := ipsearch.FindOverlaps(ipRanges)
+	assert.Equal(t, 3, len(overlaps))
+	for _, o := range overlaps {
+		assert.Equal(t, "10.0.0.0/16", o.First.CIDR())
+	}
+
+	ipRanges = ipsearch.NewIPRangeSlice([]string{
+		"1.0.0.0,1.0.0.255,AU",
+		"1.0.0.128,1.0.1.255,CN",
+	}, ipsearch.Geo)
+	overlaps = ipsearch.FindOverlaps(ipRanges)
+	assert.Equal(t, 1, len(overlaps))
+	assert.Equal(t, "[1.0.0.0 - 1.0.0.255] and [1.0.0.128 - 1.0.1.255]", overlaps[0].String())
+}
+
+func TestResolveOverlaps(t *testing.T) {
+	ipRanges := ipsearch.NewIPRangeSlice(overlapCIDRs, ipsearch.CIDR)
+
+	_, overlaps, err := ipsearch.ResolveOverlaps(ipRanges, ipsearch.OverlapReject)
+	assert.True(t, errors.Is(err, ipsearch.ErrOverlap))
+	assert.Equal(t, 3, len(overlaps))
+
+	resolved, _, err := ipsearch.ResolveOverlaps(ipRanges, ipsearch.OverlapAllow)
+	assert.Nil(t, err)
+	assert.Equal(t, ipRanges, resolved)
+
+	type testData struct {
+		ip   string
+		cidr string
+	}
+	tests := map[ipsearch.OverlapPolicy][]testData{
+		ipsearch.OverlapMostSpecific: {
+			{"10.0.0.1", "10.0.0.0/16"},
+			{"10.0.1.1", "10.0.1.0/24"},
+			{"10.0.2.1", "10.0.0.0/16"},
+			{"10.0.255.200", "10.0.255.128/25"},
+			{"192.168.0.1", "192.168.0.0/24"},
+		},
+		ipsearch.OverlapFirstWins: {
+			{"10.0.1.1", "10.0.0.0/16"},
+			{"10.0.255.200", "10.0.0.0/16"},
+		},
+		ipsearch.OverlapLastWins: {
+			{"10.0.0.1", "10.0.0.0/16"},
+			{"10.0.1.1", "10.0.0.0/16"},
+			{"10.0.255.200", "10.0.255.128/25"},
+		},
+	}
+	for policy, data := range tests {
+		resolved, _, err := ipsearch.ResolveOverlaps(ipRanges, policy)
+		assert.Nil(t, err)
+		assert.Empty(t, ipsearch.FindOverlaps(resolved))
+		list := ipsearch.IPRangeList(resolved)
+		list.Sort()
+		for _, d := range data {
+			ip := list.Search(d.ip)
+			assert.NotNil(t, ip, d.ip)
+			assert.Equal(t, d.cidr, ip.CIDR(), d.ip)
+		}
+	}
+
+	resolved, _, _ = ipsearch.ResolveOverlaps(ipRanges, ipsearch.OverlapMostSpecific)
+	list := ipsearch.IPRangeList(resolved)
+	assert.Equal(t, "10.0.0.0 - 10.0.0.255\n"+
+		"10.0.1.0 - 10.0.1.255\n"+
+		"10.0.2.0 - 10.0.255.127\n"+
+		"10.0.255.128 - 10.0.255.255\n"+
+		"192.168.0.0 - 192.168.0.255\n", list.Range())
+
+	// the whole address space
+	ipRanges = ipsearch.NewIPRangeSlice([]string{"::/0", "2001:db8::/32"}, ipsearch.CIDR)
+	resolved, _, err = ipsearch.ResolveOverlaps(ipRanges, ipsearch.OverlapMostSpecific)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(resolved))
+	assert.Equal(t, "2001:db8::/32", resolved[1].CIDR())
+	assert.Equal(t, "2001:db9:: - ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", resolved[2].Range())
+}
+
+func TestLoadWithOverlapPolicy(t *testing.T) {
+	opts := &ipsearch.LoadOptions{OnOverlap: ipsearch.OverlapReject}
+	_, report, err := ipsearch.NewIPSearchWithOptions(overlapCIDRs, ipsearch.CIDR, opts)
+	assert.True(t, errors.Is(err, ipsearch.ErrOverlap))
+	assert.Equal(t, 3, len(report.Overlaps))
+
+	opts.OnOverlap = ipsearch.OverlapMostSpecific
+	search, report, err := ipsearch.NewIPSearchWithOptions(overlapCIDRs, ipsearch.CIDR, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(report.Overlaps))
+	assert.Equal(t, "10.0.1.0/24", search.Search("10.0.1.1").CIDR())
+	assert.Equal(t, "10.0.0.0/16", search.Search("10.0.3.1").CIDR())
+
+	_, report, err = ipsearch.NewIPSearchWithFileOptions(IPv4CIDRFile, ipsearch.CIDR,
+		&ipsearch.LoadOptions{OnOverlap: ipsearch.OverlapReject})
+	assert.Nil(t, err)
+	assert.Empty(t, report.Overlaps)
+}
@@ -0,0 +1,207 @@
+package ipsearch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrInvalidIP is returned when an IP address cannot be parsed.
+	ErrInvalidIP = errors.New("invalid IP address")
+	// ErrInvalidCIDR is returned when a CIDR cannot be parsed.
+	ErrInvalidCIDR = errors.New("invalid CIDR")
+	// ErrInvalidGeo is returned when a Geo CSV line cannot be parsed.
+	ErrInvalidGeo = errors.New("invalid Geo line")
+	// ErrInvalidIP2Location is returned when an IP2Location CSV line cannot be parsed.
+	ErrInvalidIP2Location = errors.New("invalid IP2Location line")
+	// ErrInvalidDelegated is returned when a delegated stats line cannot be parsed.
+	ErrInvalidDelegated = errors.New("invalid delegated stats line")
+	// ErrInvalidASN is returned when an ASN CSV line cannot be parsed.
+	ErrInvalidASN = errors.New("invalid ASN line")
+	// ErrInvalidCSV is returned when a row of a delimited file or a CSVFormat is invalid.
+	ErrInvalidCSV = errors.New("invalid CSV")
+	// ErrInvalidRangeType is returned for an unknown RangeType.
+	ErrInvalidRangeType = errors.New("invalid range type")
+)
+
+// LineError records a malformed line of an IP range list.
+type LineError struct {
+	Line int // 1-based line number
+	Text string
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %q: %v", e.Line, e.Text, e.Err)
+}
+
+// Unwrap returns the underlying parse error.
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors is the list of all malformed lines of an IP range list.
+type ParseErrors []*LineError
+
+func (e ParseErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d malformed lines:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// ParseIP parses an IPv4 or IPv6 address, IPv4 addresses are returned in
+// the IPv4-mapped form.
+func ParseIP(ip string) (Uint128, error) {
+	if IsIPv6(ip) {
+		if v6, ok := parseIPv6(ip); ok {
+			return v6, nil
+		}
+	} else if v4, ok := parseIPv4(ip); ok {
+		return ipv4To128(v4), nil
+	}
+	return Uint128{}, fmt.Errorf("%w: %q", ErrInvalidIP, ip)
+}
+
+// ParseCIDR parses an IPv4 or IPv6 CIDR range, the mask is mandatory.
+func ParseCIDR(cidr string) (*IPRange, error) {
+	i := strings.IndexByte(cidr, '/')
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %q: missing mask", ErrInvalidCIDR, cidr)
+	}
+	ip, err := ParseIP(cidr[:i])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidCIDR, cidr, err)
+	}
+
+	maxMask := 128
+	if !IsIPv6(cidr) {
+		maxMask = 32
+	}
+	mask, ok := parseMask(cidr[i+1:], maxMask)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q: bad mask", ErrInvalidCIDR, cidr)
+	}
+	if !IsIPv6(cidr) {
+		mask += 96
+	}
+
+	host := hostMask(mask)
+	start := ip.andNot(host)
+	return &IPRange{
+		rangeType: CIDR,
+		bucket:    bucketOf(start),
+		start:     start,
+		end:       ip.or(host),
+		cidr:      cidr,
+	}, nil
+}
+
+// ParseGeoLine parses a Geo CSV line in the "start,end,country" format.
+func ParseGeoLine(line string) (*IPRange, error) {
+	fields := strings.Split(line, ",")
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("%w: %q: expected 3 fields, got %d", ErrInvalidGeo, line, len(fields))
+	}
+	start, err := ParseIP(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidGeo, err)
+	}
+	end, err := ParseIP(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidGeo, err)
+	}
+	if start.IsIPv4() != end.IsIPv4() {
+		return nil, fmt.Errorf("%w: %q: mixed IPv4 and IPv6 addresses", ErrInvalidGeo, line)
+	}
+	if end.Less(start) {
+		return nil, fmt.Errorf("%w: %q: start is greater than end", ErrInvalidGeo, line)
+	}
+	if fields[2] == "" {
+		return nil, fmt.Errorf("%w: %q: empty country", ErrInvalidGeo, line)
+	}
+	return &IPRange{
+		rangeType: Geo,
+		bucket:    bucketOf(start),
+		start:     start,
+		end:       end,
+		country:   fields[2],
+	}, nil
+}
+
+// ParseIPRange parses a line of an IP range list with the given type, it
+// returns a nil range and a nil error for a valid line without an IP range,
+// like the header of a delegated stats file.
+func ParseIPRange(line string, rangeType RangeType) (*IPRange, error) {
+	switch rangeType {
+	case CIDR:
+		return ParseCIDR(line)
+	case Geo:
+		return ParseGeoLine(line)
+	case IP2Location:
+		return ParseIP2LocationLine(line)
+	case Delegated:
+		return ParseDelegatedLine(line)
+	case ASN:
+		return ParseASNLine(line)
+	}
+	return nil, fmt.Errorf("%w: %d", ErrInvalidRangeType, rangeType)
+}
+
+// checkLineRangeType returns ErrInvalidRangeType for the range types of the
+// binary files, which are not read line by line.
+func checkLineRangeType(rangeType RangeType) error {
+	switch rangeType {
+	case MMDB:
+		return fmt.Errorf("%w: an MMDB file is loaded by NewIPSearchWithMMDB", ErrInvalidRangeType)
+	case IP2Region:
+		return fmt.Errorf("%w: an ip2region xdb file is loaded by NewIPSearchWithIP2Region", ErrInvalidRangeType)
+	}
+	return nil
+}
+
+// ParseIPRanges parses all of the lines of an IP range list, it returns a
+// ParseErrors with every malformed line if any, or ErrInvalidRangeType for
+// the range type of a binary file, like MMDB or IP2Region.
+func ParseIPRanges(lines []string, rangeType RangeType) ([]*IPRange, error) {
+	if err := checkLineRangeType(rangeType); err != nil {
+		return nil, err
+	}
+	var errs ParseErrors
+	ipRanges := make([]*IPRange, 0, len(lines))
+	for i, line := range lines {
+		ipRange, err := ParseIPRange(line, rangeType)
+		if err != nil {
+			errs = append(errs, &LineError{Line: i + 1, Text: line, Err: err})
+			continue
+		}
+		if ipRange == nil {
+			continue
+		}
+		ipRanges = append(ipRanges, ipRange)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return ipRanges, nil
+}
+
+// parseMask parses a decimal prefix length in [0, max].
+func parseMask(s string, max int) (int, bool) {
+	if len(s) == 0 || len(s) > 3 {
+		return 0, false
+	}
+	mask := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+		mask = mask*10 + int(s[i]-'0')
+	}
+	return mask, mask <= max
+}
@@ -0,0 +1,109 @@
+package ipsearch_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+func TestParseIP(t *testing.T) {
+	ip, err := ipsearch.ParseIP("1.2.3.4")
+	assert.Nil(t, err)
+	assert.Equal(t, ipsearch.IPStrToInt("1.2.3.4"), ip.Uint32())
+
+	ip, err = ipsearch.ParseIP("2001:db8::1")
+	assert.Nil(t, err)
+	assert.Equal(t, ipv6ToInt("2001:db8::1"), ip)
+
+	for _, bad := range []string{"", "garbage", "1.2.3", "1.2.3.4.5", "256.1.1.1", "1.2.3.4 ", "1..2.3", "2001:db8::g", "1:2:3"} {
+		_, err = ipsearch.ParseIP(bad)
+		assert.True(t, errors.Is(err, ipsearch.ErrInvalidIP), bad)
+	}
+}
+
+func TestParseCIDR(t *testing.T) {
+	ip, err := ipsearch.ParseCIDR("1.0.1.0/24")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.0.1.0 - 1.0.1.255", ip.Range())
+	assert.Equal(t, "1.0.1.0/24", ip.CIDR())
+
+	ip, err = ipsearch.ParseCIDR("0.0.0.0/0")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.0.0.0 - 255.255.255.255", ip.Range())
+
+	ip, err = ipsearch.ParseCIDR("2001:db8::/32")
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8:: - 2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", ip.Range())
+
+	for _, bad := range []string{"1.0.1.0", "1.0.1.0/", "1.0.1.0/33", "1.0.1.0/-1", "1.0.1/24", "2001:db8::/129", "garbage/8"} {
+		_, err = ipsearch.ParseCIDR(bad)
+		assert.True(t, errors.Is(err, ipsearch.ErrInvalidCIDR), bad)
+	}
+}
+
+func TestParseGeoLine(t *testing.T) {
+	ip, err := ipsearch.ParseGeoLine("1.0.32.0,1.0.63.255,CN")
+	assert.Nil(t, err)
+	assert.Equal(t, "CN", ip.Country())
+	assert.Equal(t, "1.0.32.0,1.0.63.255,CN", ip.String())
+
+	for _, bad := range []string{
+		"1.0.32.0,1.0.63.255",
+		"1.0.32.0,1.0.63.255,CN,extra",
+		"1.0.32.0,bad,CN",
+		"1.0.63.255,1.0.32.0,CN",
+		"1.0.32.0,2001:db8::,CN",
+		"1.0.32.0,1.0.63.255,",
+	} {
+		_, err = ipsearch.ParseGeoLine(bad)
+		assert.True(t, errors.Is(err, ipsearch.ErrInvalidGeo), bad)
+	}
+}
+
+func TestParseIPRanges(t *testing.T) {
+	ipRanges, err := ipsearch.ParseIPRanges(cidrs, ipsearch.CIDR)
+	assert.Nil(t, err)
+	assert.Equal(t, len(cidrs), len(ipRanges))
+
+	lines := []string{"1.0.1.0/24", "garbage", "1.0.2.0/23", "1.0.8.0"}
+	ipRanges, err = ipsearch.ParseIPRanges(lines, ipsearch.CIDR)
+	assert.Nil(t, ipRanges)
+	var errs ipsearch.ParseErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, 2, errs[0].Line)
+	assert.Equal(t, "garbage", errs[0].Text)
+	assert.Equal(t, 4, errs[1].Line)
+	assert.True(t, errors.Is(errs[1], ipsearch.ErrInvalidCIDR))
+
+	_, err = ipsearch.ParseIPRanges(lines, ipsearch.RangeType(100))
+	assert.True(t, errors.As(err, &errs))
+	assert.True(t, errors.Is(errs[0], ipsearch.ErrInvalidRangeType))
+}
+
+func TestNewIPSearchStrict(t *testing.T) {
+	search, err := ipsearch.NewIPSearchStrict(geo, ipsearch.Geo)
+	assert.Nil(t, err)
+	ip := search.Search("1.0.35.10")
+	assert.NotNil(t, ip)
+	assert.Equal(t, "CN", ip.Country())
+
+	search, err = ipsearch.NewIPSearchStrict([]string{"1.0.32.0,1.0.63.255,CN", "1.0.64.0"}, ipsearch.Geo)
+	assert.Nil(t, search)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+
+	_, err = ipsearch.NewIPSearchWithFileStrict("not-exist-file", ipsearch.CIDR)
+	assert.NotNil(t, err)
+
+	search, err = ipsearch.NewIPSearchWithFileStrict(IPv4CIDRFile, ipsearch.CIDR)
+	assert.Nil(t, err)
+	testCIDRSearch(t, search)
+
+	search, err = ipsearch.NewIPSearchWithFileStrict(IPv4GeoFile, ipsearch.Geo)
+	assert.Nil(t, err)
+	testGeoSearch(t, search)
+}
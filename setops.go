@@ -0,0 +1,176 @@
+package ipsearch
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The set operations treat the containers as the sets of the IP addresses,
+// the results are the normalized lists: the sorted, non-overlapping CIDR
+// ranges, the minimal prefixes of the merged ranges, like the firewall
+// configs expect. The country, the ASN and the metadata of the ranges are
+// dropped. To operate on an IPSearch, use IPSearch.Container, and to search
+// a result, use NewIPSearchWithContainer.
+
+// ipInterval is a range of the addresses, from start to end inclusive.
+type ipInterval struct {
+	start Uint128
+	end   Uint128
+}
+
+// Union returns the addresses in any of the containers.
+func Union(containers ...Container) IPRangeList {
+	var intervals []ipInterval
+	for _, c := range containers {
+		c.Walk(func(ip *IPRange) bool {
+			intervals = append(intervals, ipInterval{ip.start, ip.end})
+			return true
+		})
+	}
+	return intervalsToList(normalizeIntervals(intervals))
+}
+
+// Intersect returns the addresses in all of the containers, it returns an
+// empty list for no container.
+func Intersect(containers ...Container) IPRangeList {
+	if len(containers) == 0 {
+		return IPRangeList{}
+	}
+	result := containerIntervals(containers[0])
+	for _, c := range containers[1:] {
+		result = intersectIntervals(result, containerIntervals(c))
+	}
+	return intervalsToList(result)
+}
+
+// Subtract returns the addresses in the container but not in any of the
+// others, like the customer ranges minus the China list.
+func Subtract(c Container, others ...Container) IPRangeList {
+	result := containerIntervals(c)
+	for _, other := range others {
+		result = subtractIntervals(result, containerIntervals(other))
+	}
+	return intervalsToList(result)
+}
+
+// ComplementIPv4 returns the IPv4 addresses not in the container, like all of
+// the non-China IPv4 space.
+func ComplementIPv4(c Container) IPRangeList {
+	universe := []ipInterval{{ipv4To128(0), ipv4To128(0xFFFFFFFF)}}
+	return intervalsToList(subtractIntervals(universe, containerIntervals(c)))
+}
+
+// ComplementIPv6 returns the IPv6 addresses not in the container, the IPv4
+// addresses (::ffff:0:0/96) are not included.
+func ComplementIPv6(c Container) IPRangeList {
+	beforeV4, _ := ipv4To128(0).subOne()
+	afterV4, _ := ipv4To128(0xFFFFFFFF).addOne()
+	universe := []ipInterval{{Uint128{}, beforeV4}, {afterV4, Uint128{^uint64(0), ^uint64(0)}}}
+	return intervalsToList(subtractIntervals(universe, containerIntervals(c)))
+}
+
+// containerIntervals returns the normalized intervals of a container.
+func containerIntervals(c Container) []ipInterval {
+	var intervals []ipInterval
+	c.Walk(func(ip *IPRange) bool {
+		intervals = append(intervals, ipInterval{ip.start, ip.end})
+		return true
+	})
+	return normalizeIntervals(intervals)
+}
+
+// normalizeIntervals sorts the intervals, and merges the overlapping and the
+// adjacent ones.
+func normalizeIntervals(intervals []ipInterval) []ipInterval {
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].start.Less(intervals[j].start)
+	})
+	var merged []ipInterval
+	for _, iv := range intervals {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			next, ok := last.end.addOne()
+			if !ok || !next.Less(iv.start) {
+				if last.end.Less(iv.end) {
+					last.end = iv.end
+				}
+				continue
+			}
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// intersectIntervals returns the intersection of two normalized lists.
+func intersectIntervals(a, b []ipInterval) []ipInterval {
+	var result []ipInterval
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start, end := a[i].start, a[i].end
+		if start.Less(b[j].start) {
+			start = b[j].start
+		}
+		if b[j].end.Less(end) {
+			end = b[j].end
+		}
+		if !end.Less(start) {
+			result = append(result, ipInterval{start, end})
+		}
+		if a[i].end.Less(b[j].end) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// subtractIntervals returns the addresses of a not in b, both normalized.
+func subtractIntervals(a, b []ipInterval) []ipInterval {
+	var result []ipInterval
+	j := 0
+	for _, iv := range a {
+		start := iv.start
+		for j < len(b) && b[j].end.Less(start) {
+			j++
+		}
+		removed := false
+		for k := j; k < len(b) && !iv.end.Less(b[k].start); k++ {
+			if start.Less(b[k].start) {
+				end, _ := b[k].start.subOne()
+				result = append(result, ipInterval{start, end})
+			}
+			next, ok := b[k].end.addOne()
+			if !ok || iv.end.Less(next) {
+				removed = true
+				break
+			}
+			start = next
+		}
+		if !removed {
+			result = append(result, ipInterval{start, iv.end})
+		}
+	}
+	return result
+}
+
+// intervalsToList decomposes the intervals into the CIDR ranges.
+func intervalsToList(intervals []ipInterval) IPRangeList {
+	list := IPRangeList{}
+	for _, iv := range intervals {
+		for _, p := range rangeToPrefixes(iv.start, iv.end) {
+			bits := p.bits
+			if p.ip.IsIPv4() {
+				bits -= 96
+			}
+			list = append(list, &IPRange{
+				rangeType: CIDR,
+				bucket:    bucketOf(p.ip),
+				start:     p.ip,
+				end:       p.ip.or(hostMask(p.bits)),
+				cidr:      fmt.Sprintf("%s/%d", ipToStr(p.ip), bits),
+			})
+		}
+	}
+	return list
+}
@@ -0,0 +1,147 @@
+package ipsearch_test
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+// setSpace is the first address of the small space of the random sets, so
+// the ranges overlap often.
+const setSpace = 0x0A000000 // 10.0.0.0
+
+const setSpaceSize = 1024
+
+// randomSet is a random set of the IPv4 ranges in the small space, with the
+// membership of every address of the space.
+type randomSet struct {
+	list    ipsearch.IPRangeList
+	members [setSpaceSize]bool
+}
+
+func (*randomSet) Generate(r *rand.Rand, size int) reflect.Value {
+	s := &randomSet{list: ipsearch.IPRangeList{}}
+	for n := r.Intn(8); n > 0; n-- {
+		var ipRange *ipsearch.IPRange
+		var start, end int
+		if r.Intn(2) == 0 {
+			// a CIDR of the space
+			bits := 22 + r.Intn(11)
+			hosts := 1 << (32 - bits)
+			start = r.Intn(setSpaceSize/hosts) * hosts
+			end = start + hosts - 1
+			ipRange = ipsearch.NewIPRange(fmt.Sprintf("%s/%d", ipsearch.IPIntToStr(uint32(setSpace+start)), bits), ipsearch.CIDR)
+		} else {
+			start = r.Intn(setSpaceSize)
+			end = start + r.Intn(setSpaceSize-start)
+			ipRange = ipsearch.NewIPRange(fmt.Sprintf("%s,%s,ZZ",
+				ipsearch.IPIntToStr(uint32(setSpace+start)), ipsearch.IPIntToStr(uint32(setSpace+end))), ipsearch.Geo)
+		}
+		s.list.Append(ipRange)
+		for i := start; i <= end; i++ {
+			s.members[i] = true
+		}
+	}
+	return reflect.ValueOf(s)
+}
+
+func setString(list ipsearch.IPRangeList) string {
+	return list.String()
+}
+
+// assertNormalized checks the list is sorted, non-overlapping and minimal,
+// and has the members of the space.
+func assertNormalized(t *testing.T, list ipsearch.IPRangeList, members func(i int) bool) bool {
+	ok := true
+	for i := 1; i < len(list); i++ {
+		prevEnd, _ := ipsearch.ParseIP(strings.Split(list[i-1].Range(), " - ")[1])
+		start, _ := ipsearch.ParseIP(strings.Split(list[i].Range(), " - ")[0])
+		ok = ok && assert.True(t, prevEnd.Less(start), "%s %s", list[i-1], list[i])
+	}
+	// the normalization is idempotent
+	ok = ok && assert.Equal(t, list.String(), setString(ipsearch.Union(&list)))
+	for i := 0; i < setSpaceSize; i++ {
+		found := list.SearchUint32(uint32(setSpace+i)) != nil
+		ok = ok && assert.Equal(t, members(i), found, ipsearch.IPIntToStr(uint32(setSpace+i)))
+	}
+	return ok
+}
+
+func TestSetOperations(t *testing.T) {
+	config := &quick.Config{MaxCount: 200, Rand: rand.New(rand.NewSource(1))}
+	err := quick.Check(func(a, b, c *randomSet) bool {
+		union := ipsearch.Union(&a.list, &b.list, &c.list)
+		intersect := ipsearch.Intersect(&a.list, &b.list, &c.list)
+		subtract := ipsearch.Subtract(&a.list, &b.list, &c.list)
+		complement := ipsearch.ComplementIPv4(&a.list)
+		return assertNormalized(t, union, func(i int) bool { return a.members[i] || b.members[i] || c.members[i] }) &&
+			assertNormalized(t, intersect, func(i int) bool { return a.members[i] && b.members[i] && c.members[i] }) &&
+			assertNormalized(t, subtract, func(i int) bool { return a.members[i] && !b.members[i] && !c.members[i] }) &&
+			assertNormalized(t, complement, func(i int) bool { return !a.members[i] })
+	}, config)
+	assert.Nil(t, err)
+}
+
+func TestSetOperationsLaws(t *testing.T) {
+	config := &quick.Config{MaxCount: 200, Rand: rand.New(rand.NewSource(2))}
+	all := ipsearch.NewIPRangeList([]string{"0.0.0.0/0"}, ipsearch.CIDR)
+	err := quick.Check(func(a, b *randomSet) bool {
+		notA, notB := ipsearch.ComplementIPv4(&a.list), ipsearch.ComplementIPv4(&b.list)
+		union, intersect := ipsearch.Union(&a.list, &b.list), ipsearch.Intersect(&a.list, &b.list)
+		return assert.Equal(t, setString(ipsearch.Union(&a.list)), setString(ipsearch.ComplementIPv4(&notA))) &&
+			assert.Equal(t, all.String(), setString(ipsearch.Union(&a.list, &notA))) &&
+			assert.Empty(t, ipsearch.Intersect(&a.list, &notA)) &&
+			assert.Equal(t, setString(ipsearch.Intersect(&a.list, &notB)), setString(ipsearch.Subtract(&a.list, &b.list))) &&
+			assert.Equal(t, setString(ipsearch.ComplementIPv4(&union)), setString(ipsearch.Intersect(&notA, &notB))) &&
+			assert.Equal(t, setString(ipsearch.ComplementIPv4(&intersect)), setString(ipsearch.Union(&notA, &notB))) &&
+			assert.Equal(t, union.String(), setString(ipsearch.Union(&b.list, &a.list)))
+	}, config)
+	assert.Nil(t, err)
+}
+
+func TestSetOperationsIPv6(t *testing.T) {
+	china := ipsearch.NewIPRangeList(append(cidrs6, "1.0.1.0/24", "1.0.2.0/23"), ipsearch.CIDR)
+	customers := ipsearch.NewIPRangeList([]string{"1.0.0.0/22", "2001:db8::/31", "240e::/16"}, ipsearch.CIDR)
+
+	assert.Equal(t, "1.0.0.0/24\n2001:db9::/32\n240e:1000::/20\n240e:2000::/19\n240e:4000::/18\n240e:8000::/17\n",
+		setString(ipsearch.Subtract(&customers, &china)))
+	assert.Equal(t, "1.0.1.0/24\n1.0.2.0/23\n2001:db8::/32\n240e::/20\n", setString(ipsearch.Intersect(&customers, &china)))
+
+	// the complements are per address family
+	notChina := ipsearch.ComplementIPv4(&china)
+	assert.Equal(t, "0.0.0.0/8", notChina[0].CIDR())
+	assert.Nil(t, notChina.Search("1.0.1.1"))
+	assert.NotNil(t, notChina.Search("8.8.8.8"))
+	assert.Nil(t, notChina.Search("2001:db8::1"))
+
+	notChina6 := ipsearch.ComplementIPv6(&china)
+	// the IPv6 space before the IPv4 addresses
+	assert.Equal(t, "::/81", notChina6[0].CIDR())
+	assert.Nil(t, notChina6.Search("8.8.8.8"))
+	assert.Nil(t, notChina6.Search("240e::1"))
+	assert.NotNil(t, notChina6.Search("2001:db9::1"))
+	assert.NotNil(t, notChina6.Search("::1"))
+	china6 := ipsearch.ComplementIPv6(&notChina6)
+	v4 := ipsearch.ComplementIPv4(&ipsearch.IPRangeList{})
+	assert.Equal(t, "::/0\n", setString(ipsearch.Union(&notChina6, &china6, &v4)))
+
+	// the IPSearch is searched by its container, and a result can be searched
+	search, err := ipsearch.NewIPSearchWithFile(IPv4CIDRFile, ipsearch.CIDR)
+	assert.Nil(t, err)
+	notChina = ipsearch.ComplementIPv4(search.Container())
+	result := ipsearch.NewIPSearchWithContainer(&notChina)
+	assert.Nil(t, result.Search("1.0.1.1"))
+	assert.NotNil(t, result.Search("8.8.8.8"))
+	assert.Equal(t, setString(ipsearch.Union(search.Container())), setString(ipsearch.ComplementIPv4(&notChina)))
+
+	assert.Empty(t, ipsearch.Intersect())
+	assert.Empty(t, ipsearch.Union())
+	assert.Equal(t, "0.0.0.0/0\n", setString(ipsearch.ComplementIPv4(&ipsearch.IPRangeList{})))
+}
@@ -0,0 +1,280 @@
+package ipsearch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// The snapshot is a compact binary format of the split and sorted ranges
+// of an IPSearch, all of the integers are little endian:
+//
+//	magic       [8]byte "IPSEARCH"
+//	version     uint16
+//	strings     uint32 count, then uint16 length and bytes for each string
+//	v4 ranges   uint32 count, the start array and the end array of uint32
+//	v6 ranges   uint32 count, the start array and the end array of 2*uint64
+//	types       uint8 for each range, v4 ranges first
+//	countries   uint32 string index for each range
+//	cidrs       uint32 string index for each range
+//	asns        uint32 for each range
+//	orgs        uint32 string index for each range
+//	metas       uint32 string index of the encoded metadata for each range
+//	checksum    uint32 CRC-32 (IEEE) of all of the above
+//
+// The strings are interned, the index 0 is always the empty string.
+const (
+	snapshotMagic   = "IPSEARCH"
+	snapshotVersion = 3
+)
+
+// ErrBadSnapshot is returned when a snapshot is corrupted or unsupported.
+var ErrBadSnapshot = errors.New("bad snapshot")
+
+// WriteSnapshot writes the ranges of the IPSearch to a snapshot, which can
+// be loaded by LoadSnapshot.
+func (s *IPSearch) WriteSnapshot(w io.Writer) error {
+	v4, v6 := splitSorted(s.Container())
+	ranges := append(v4, v6...)
+
+	strIndex := map[string]uint32{"": 0}
+	strs := []string{""}
+	intern := func(str string) uint32 {
+		i, ok := strIndex[str]
+		if !ok {
+			i = uint32(len(strs))
+			strIndex[str] = i
+			strs = append(strs, str)
+		}
+		return i
+	}
+	countries := make([]uint32, len(ranges))
+	cidrs := make([]uint32, len(ranges))
+	orgs := make([]uint32, len(ranges))
+	metas := make([]uint32, len(ranges))
+	for i, ip := range ranges {
+		countries[i] = intern(ip.country)
+		cidrs[i] = intern(ip.cidr)
+		orgs[i] = intern(ip.org)
+		metas[i] = intern(ip.meta.encode())
+	}
+
+	buf := make([]byte, 0, 64+len(v4)*8+len(v6)*32+len(ranges)*21)
+	buf = append(buf, snapshotMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, snapshotVersion)
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(strs)))
+	for _, str := range strs {
+		if len(str) > 0xFFFF {
+			return fmt.Errorf("%w: string too long: %q...", ErrBadSnapshot, str[:32])
+		}
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(str)))
+		buf = append(buf, str...)
+	}
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v4)))
+	for _, ip := range v4 {
+		buf = binary.LittleEndian.AppendUint32(buf, ip.start.Uint32())
+	}
+	for _, ip := range v4 {
+		buf = binary.LittleEndian.AppendUint32(buf, ip.end.Uint32())
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(v6)))
+	for _, ip := range v6 {
+		buf = append128(buf, ip.start)
+	}
+	for _, ip := range v6 {
+		buf = append128(buf, ip.end)
+	}
+
+	for _, ip := range ranges {
+		buf = append(buf, byte(ip.rangeType))
+	}
+	for _, i := range countries {
+		buf = binary.LittleEndian.AppendUint32(buf, i)
+	}
+	for _, i := range cidrs {
+		buf = binary.LittleEndian.AppendUint32(buf, i)
+	}
+	for _, ip := range ranges {
+		buf = binary.LittleEndian.AppendUint32(buf, ip.asn)
+	}
+	for _, i := range orgs {
+		buf = binary.LittleEndian.AppendUint32(buf, i)
+	}
+	for _, i := range metas {
+		buf = binary.LittleEndian.AppendUint32(buf, i)
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// LoadSnapshot creates a new IPSearch struct from a snapshot written by
+// WriteSnapshot, the IPSearch uses the MapListBackend and cannot be reloaded.
+func LoadSnapshot(r io.Reader) (*IPSearch, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(snapshotMagic)+2+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrBadSnapshot)
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	d := &snapshotDecoder{data: body[len(snapshotMagic):]}
+	if version := d.uint16(); version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, version)
+	}
+
+	strs := make([]string, d.count(2))
+	for i := range strs {
+		strs[i] = string(d.bytes(int(d.uint16())))
+	}
+
+	n4 := d.count(8)
+	v4 := d.bytes(n4 * 8)
+	n6 := d.count(32)
+	v6 := d.bytes(n6 * 32)
+	n := n4 + n6
+	types := d.bytes(n)
+	countries := d.bytes(n * 4)
+	cidrs := d.bytes(n * 4)
+	asns := d.bytes(n * 4)
+	orgs := d.bytes(n * 4)
+	metas := d.bytes(n * 4)
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.data) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrBadSnapshot, len(d.data))
+	}
+
+	str := func(idx []byte, i int) (string, error) {
+		j := binary.LittleEndian.Uint32(idx[i*4:])
+		if int(j) >= len(strs) {
+			return "", fmt.Errorf("%w: string index out of range", ErrBadSnapshot)
+		}
+		return strs[j], nil
+	}
+
+	// allocate all of the ranges at once
+	slab := make([]IPRange, n)
+	decoded := make(map[uint32]metadata)
+	var ok bool
+	m := NewIPRangeMapList()
+	for i := range slab {
+		ip := &slab[i]
+		if i < n4 {
+			ip.start = ipv4To128(binary.LittleEndian.Uint32(v4[i*4:]))
+			ip.end = ipv4To128(binary.LittleEndian.Uint32(v4[(n4+i)*4:]))
+		} else {
+			j := i - n4
+			ip.start = read128(v6[j*16:])
+			ip.end = read128(v6[(n6+j)*16:])
+		}
+		ip.rangeType = RangeType(types[i])
+		ip.bucket = rangeBucket(ip.start, ip.end)
+		if ip.country, err = str(countries, i); err != nil {
+			return nil, err
+		}
+		if ip.cidr, err = str(cidrs, i); err != nil {
+			return nil, err
+		}
+		ip.asn = binary.LittleEndian.Uint32(asns[i*4:])
+		if ip.org, err = str(orgs, i); err != nil {
+			return nil, err
+		}
+		// the ranges with the same metadata share it
+		j := binary.LittleEndian.Uint32(metas[i*4:])
+		if j != 0 {
+			if ip.meta, ok = decoded[j]; !ok {
+				var encoded string
+				if encoded, err = str(metas, i); err != nil {
+					return nil, err
+				}
+				ip.meta = decodeMetadata(encoded)
+				decoded[j] = ip.meta
+			}
+		}
+		m.Append(ip)
+	}
+	return newIPSearch(m, nil), nil
+}
+
+// splitSorted returns the split ranges of a container, sorted by the start
+// addresses, the IPv4 and IPv6 ranges apart. The overlapping ranges are
+// resolved to the disjoint pieces, see disjointRanges.
+func splitSorted(c Container) (v4, v6 []*IPRange) {
+	for _, ipRange := range disjointRanges(c) {
+		for _, ip := range ipRange.Split() {
+			if ip.start.IsIPv4() {
+				v4 = append(v4, ip)
+			} else {
+				v6 = append(v6, ip)
+			}
+		}
+	}
+	// the ranges from an IPRangeMapList are sorted already, not the others
+	for _, list := range [][]*IPRange{v4, v6} {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].start.Less(list[j].start) })
+	}
+	return v4, v6
+}
+
+func append128(buf []byte, u Uint128) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, u.Hi)
+	return binary.LittleEndian.AppendUint64(buf, u.Lo)
+}
+
+func read128(b []byte) Uint128 {
+	return Uint128{binary.LittleEndian.Uint64(b), binary.LittleEndian.Uint64(b[8:])}
+}
+
+// snapshotDecoder reads the snapshot sections, the first error is kept.
+type snapshotDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *snapshotDecoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = fmt.Errorf("%w: truncated", ErrBadSnapshot)
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *snapshotDecoder) uint16() uint16 {
+	if b := d.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+// count reads a uint32 count, and checks there are at least count*size
+// bytes left, to avoid allocating for a corrupted count.
+func (d *snapshotDecoder) count(size int) int {
+	b := d.bytes(4)
+	if b == nil {
+		return 0
+	}
+	n := int(binary.LittleEndian.Uint32(b))
+	if n*size > len(d.data) {
+		d.err = fmt.Errorf("%w: truncated", ErrBadSnapshot)
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,130 @@
+package ipsearch_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+func snapshot(t testing.TB, search *ipsearch.IPSearch) []byte {
+	var buf bytes.Buffer
+	assert.Nil(t, search.WriteSnapshot(&buf))
+	return buf.Bytes()
+}
+
+func walkRanges(search *ipsearch.IPSearch) []string {
+	var ranges []string
+	search.Container().Walk(func(ip *ipsearch.IPRange) bool {
+		ranges = append(ranges, ip.String())
+		return true
+	})
+	return ranges
+}
+
+func TestSnapshot(t *testing.T) {
+	search, err := ipsearch.NewIPSearchWithFile(IPv4CIDRFile, ipsearch.CIDR)
+	assert.Nil(t, err)
+	loaded, err := ipsearch.LoadSnapshot(bytes.NewReader(snapshot(t, search)))
+	assert.Nil(t, err)
+	assert.Equal(t, search.Len(), loaded.Len())
+	assert.Equal(t, walkRanges(search), walkRanges(loaded))
+	testCIDRSearch(t, loaded)
+
+	search, err = ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	assert.Nil(t, err)
+	loaded, err = ipsearch.LoadSnapshot(bytes.NewReader(snapshot(t, search)))
+	assert.Nil(t, err)
+	assert.Equal(t, walkRanges(search), walkRanges(loaded))
+	testGeoSearch(t, loaded)
+
+	// the loaded IPSearch cannot be reloaded
+	_, err = loaded.Reload(geo)
+	assert.True(t, errors.Is(err, ipsearch.ErrReloadNotSupported))
+}
+
+func TestSnapshotIPv6(t *testing.T) {
+	for _, backend := range []ipsearch.Backend{ipsearch.MapListBackend, ipsearch.TrieBackend, ipsearch.ListBackend} {
+		opts := &ipsearch.LoadOptions{Backend: backend}
+		search, _, err := ipsearch.NewIPSearchWithOptions(append(geo6, geo...), ipsearch.Geo, opts)
+		assert.Nil(t, err)
+
+		loaded, err := ipsearch.LoadSnapshot(bytes.NewReader(snapshot(t, search)))
+		assert.Nil(t, err)
+		for _, ip := range []string{"2001:db8::1", "2a00:1450:4001:82b::200e", "240e:3b7::1", "1.0.1.24", "8.8.8.8", "::1"} {
+			want, got := search.Search(ip), loaded.Search(ip)
+			assert.Equal(t, want != nil, got != nil, ip)
+			if want != nil && got != nil {
+				assert.Equal(t, want.Country(), got.Country(), ip)
+			}
+		}
+	}
+}
+
+// nestedCIDRs is an allow list with the exceptions, the trie backend
+// searches them with the longest prefix match.
+var nestedCIDRs = []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "2001:db8::/32", "2001:db8:1::/48"}
+
+// assertNestedSearch checks a search of the nestedCIDRs answers the longest
+// prefix match.
+func assertNestedSearch(t *testing.T, search interface {
+	Search(ip string) *ipsearch.IPRange
+}) {
+	for ip, cidr := range map[string]string{
+		"10.1.2.3":      "10.1.2.0/24",
+		"10.1.3.1":      "10.1.0.0/16",
+		"10.2.0.1":      "10.0.0.0/8",
+		"10.255.0.1":    "10.0.0.0/8",
+		"2001:db8:1::1": "2001:db8:1::/48",
+		"2001:db8:2::1": "2001:db8::/32",
+	} {
+		found := search.Search(ip)
+		if assert.NotNil(t, found, ip) {
+			assert.Equal(t, cidr, found.CIDR(), ip)
+		}
+	}
+	assert.Nil(t, search.Search("11.0.0.1"))
+}
+
+func TestSnapshotNested(t *testing.T) {
+	search, _, err := ipsearch.NewIPSearchWithOptions(nestedCIDRs, ipsearch.CIDR, &ipsearch.LoadOptions{Backend: ipsearch.TrieBackend})
+	assert.Nil(t, err)
+	assertNestedSearch(t, search)
+
+	loaded, err := ipsearch.LoadSnapshot(bytes.NewReader(snapshot(t, search)))
+	assert.Nil(t, err)
+	assertNestedSearch(t, loaded)
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	search := ipsearch.NewIPSearch(cidrs, ipsearch.CIDR)
+	data := snapshot(t, search)
+
+	for name, bad := range map[string][]byte{
+		"empty":     {},
+		"magic":     append([]byte("NOTMAGIC"), data[8:]...),
+		"truncated": data[:len(data)/2],
+		"flipped":   append(append([]byte{}, data[:20]...), append([]byte{data[20] ^ 0xFF}, data[21:]...)...),
+	} {
+		_, err := ipsearch.LoadSnapshot(bytes.NewReader(bad))
+		assert.True(t, errors.Is(err, ipsearch.ErrBadSnapshot), name)
+	}
+}
+
+func BenchmarkLoadSnapshot(b *testing.B) {
+	search, err := ipsearch.NewIPSearchWithFile(IPv4GeoFile, ipsearch.Geo)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := snapshot(b, search)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ipsearch.LoadSnapshot(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package ipsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrEmptyList is returned by the default validation of the Updater when
+// the new list has no IP ranges.
+var ErrEmptyList = errors.New("empty IP range list")
+
+// UpdaterOptions configures an Updater.
+type UpdaterOptions struct {
+	// Interval is the polling interval, 24 hours by default.
+	Interval time.Duration
+	// HTTP configures the download, see HTTPOptions.
+	HTTP *HTTPOptions
+	// Validate checks the new data before it is swapped in, by default the
+	// new list must not be empty.
+	Validate ValidateFunc
+	// OnUpdate is called after the new data is swapped in.
+	OnUpdate func(report *LoadReport)
+	// OnNotModified is called when the URL is not modified.
+	OnNotModified func()
+	// OnError is called when the download, the loading or the validation
+	// fails, the last good data is kept.
+	OnError func(err error)
+}
+
+// UpdaterStats is the metrics of an Updater.
+type UpdaterStats struct {
+	Checks      int64     // all of the polls
+	Updates     int64     // polls swapping in the new data
+	NotModified int64     // polls with the URL not modified
+	Failures    int64     // polls failed
+	LastCheck   time.Time // time of the last poll
+	LastUpdate  time.Time // time of the last swap
+	LastError   error     // error of the last failed poll
+}
+
+// Updater polls a URL periodically, and reloads an IPSearch when the list
+// changes. It uses the conditional requests (ETag and Last-Modified) to
+// avoid downloading the unchanged list. The malformed lines are skipped
+// even if the IPSearch is lenient, so a download which is not a list, like
+// an HTML error page, has no ranges and is rejected by the default
+// validation.
+type Updater struct {
+	search *IPSearch
+	url    string
+	opts   UpdaterOptions
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	stats        UpdaterStats
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewUpdater creates a new Updater of an IPSearch, the list is reloaded
+// with the range type and options the IPSearch was created with.
+func NewUpdater(search *IPSearch, url string, opts *UpdaterOptions) *Updater {
+	u := &Updater{search: search, url: url}
+	if opts != nil {
+		u.opts = *opts
+	}
+	if u.opts.Interval <= 0 {
+		u.opts.Interval = 24 * time.Hour
+	}
+	if u.opts.Validate == nil {
+		u.opts.Validate = func(candidate *IPSearch, _ *LoadReport) error {
+			if candidate.Len() == 0 {
+				return ErrEmptyList
+			}
+			return nil
+		}
+	}
+	return u
+}
+
+// Start starts polling the URL in the background, the first poll happens
+// right away, and the next ones every interval.
+func (u *Updater) Start() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	u.cancel = cancel
+	u.done = make(chan struct{})
+	go u.run(ctx, u.done)
+}
+
+// Stop stops polling, cancels the running poll and waits for it to finish.
+func (u *Updater) Stop() {
+	u.mu.Lock()
+	cancel, done := u.cancel, u.done
+	u.cancel, u.done = nil, nil
+	u.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (u *Updater) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	u.UpdateContext(ctx)
+	ticker := time.NewTicker(u.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			u.UpdateContext(ctx)
+		}
+	}
+}
+
+// Update polls the URL once, and reloads the IPSearch if the list changes.
+func (u *Updater) Update() error {
+	return u.UpdateContext(context.Background())
+}
+
+// UpdateContext is Update with a context.
+func (u *Updater) UpdateContext(ctx context.Context) error {
+	u.mu.Lock()
+	header := http.Header{}
+	if u.etag != "" {
+		header.Set("If-None-Match", u.etag)
+	}
+	if u.lastModified != "" {
+		header.Set("If-Modified-Since", u.lastModified)
+	}
+	u.mu.Unlock()
+
+	lines, resp, err := fetchURL(ctx, u.url, u.opts.HTTP, header)
+	if err == nil && resp.StatusCode == http.StatusNotModified {
+		u.record(func(st *UpdaterStats) { st.NotModified++ })
+		log.Debugf("IP list is not modified: %s", u.url)
+		if u.opts.OnNotModified != nil {
+			u.opts.OnNotModified()
+		}
+		return nil
+	}
+
+	var report *LoadReport
+	if err == nil {
+		load := u.search.load
+		if u.search.updateLoad != nil {
+			load = u.search.updateLoad
+		}
+		report, err = u.search.reload(lines, load, u.opts.Validate)
+	}
+	if err != nil {
+		err = fmt.Errorf("update %s: %w", u.url, err)
+		u.record(func(st *UpdaterStats) {
+			st.Failures++
+			st.LastError = err
+		})
+		log.Warnf("Failed to update the IP list, keep the last good one: %v", err)
+		if u.opts.OnError != nil {
+			u.opts.OnError(err)
+		}
+		return err
+	}
+
+	u.mu.Lock()
+	u.etag = resp.Header.Get("ETag")
+	u.lastModified = resp.Header.Get("Last-Modified")
+	u.mu.Unlock()
+	u.record(func(st *UpdaterStats) {
+		st.Updates++
+		st.LastUpdate = st.LastCheck
+	})
+	log.Debugf("IP list is updated: %s", u.url)
+	if u.opts.OnUpdate != nil {
+		u.opts.OnUpdate(report)
+	}
+	return nil
+}
+
+// Stats returns the metrics of the Updater.
+func (u *Updater) Stats() UpdaterStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.stats
+}
+
+func (u *Updater) record(fn func(st *UpdaterStats)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stats.Checks++
+	u.stats.LastCheck = time.Now()
+	fn(&u.stats)
+}
@@ -0,0 +1,222 @@
+package ipsearch_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/haoel/ipsearch"
+)
+
+// listServer serves an IP list with the ETag of its version.
+type listServer struct {
+	mu          sync.Mutex
+	body        string
+	version     int
+	requests    int
+	conditional int
+}
+
+func (s *listServer) set(body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body = body
+	s.version++
+}
+
+func (s *listServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	etag := `"v` + strconv.Itoa(s.version) + `"`
+	if r.Header.Get("If-None-Match") != "" {
+		s.conditional++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("ETag", etag)
+	w.Write([]byte(s.body))
+}
+
+func TestUpdater(t *testing.T) {
+	srv := &listServer{}
+	srv.set(strings.Join(cidrs, "\n"))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	search, err := ipsearch.NewIPSearchWithFileFromURLStrict(ts.URL, ipsearch.CIDR)
+	assert.Nil(t, err)
+
+	var updates, notModified, failures int
+	u := ipsearch.NewUpdater(search, ts.URL, &ipsearch.UpdaterOptions{
+		OnUpdate:      func(*ipsearch.LoadReport) { updates++ },
+		OnNotModified: func() { notModified++ },
+		OnError:       func(error) { failures++ },
+	})
+
+	// the first poll downloads the list, and the second one is not modified
+	assert.Nil(t, u.Update())
+	assert.Nil(t, u.Update())
+	assert.Equal(t, 1, updates)
+	assert.Equal(t, 1, notModified)
+	assert.Equal(t, 1, srv.conditional)
+
+	srv.set("10.0.0.0/8")
+	assert.Nil(t, u.Update())
+	assert.Equal(t, 2, updates)
+	assert.NotNil(t, search.Search("10.1.1.1"))
+	assert.Nil(t, search.Search("1.0.1.1"))
+
+	// malformed data, the last good data is kept
+	srv.set("10.0.0.0/8\ngarbage")
+	assert.NotNil(t, u.Update())
+	assert.NotNil(t, search.Search("10.1.1.1"))
+
+	// empty data is rejected by the default validation
+	srv.set("")
+	err = u.Update()
+	assert.True(t, errors.Is(err, ipsearch.ErrEmptyList))
+	assert.NotNil(t, search.Search("10.1.1.1"))
+	assert.Equal(t, 2, failures)
+
+	stats := u.Stats()
+	assert.Equal(t, int64(5), stats.Checks)
+	assert.Equal(t, int64(2), stats.Updates)
+	assert.Equal(t, int64(1), stats.NotModified)
+	assert.Equal(t, int64(2), stats.Failures)
+	assert.True(t, errors.Is(stats.LastError, ipsearch.ErrEmptyList))
+	assert.False(t, stats.LastUpdate.IsZero())
+}
+
+func TestUpdaterLenient(t *testing.T) {
+	srv := &listServer{}
+	srv.set("<html>\n<body>502 Bad Gateway</body>\n</html>")
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// the error page is not loaded as the zero value ranges
+	search := ipsearch.NewIPSearch(cidrs, ipsearch.CIDR)
+	u := ipsearch.NewUpdater(search, ts.URL, nil)
+	assert.True(t, errors.Is(u.Update(), ipsearch.ErrEmptyList))
+	assert.Equal(t, len(cidrs), search.Len())
+	assert.NotNil(t, search.Search("1.0.1.1"))
+	assert.Nil(t, search.Search("0.0.0.0"))
+
+	// the malformed lines are skipped
+	var report *ipsearch.LoadReport
+	u = ipsearch.NewUpdater(search, ts.URL, &ipsearch.UpdaterOptions{
+		OnUpdate: func(r *ipsearch.LoadReport) { report = r },
+	})
+	srv.set("10.0.0.0/8\ngarbage")
+	assert.Nil(t, u.Update())
+	assert.Equal(t, 1, search.Len())
+	assert.Equal(t, 1, len(report.Skipped))
+	assert.NotNil(t, search.Search("10.1.1.1"))
+
+	// Reload is still lenient
+	_, err := search.Reload([]string{"garbage"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, search.Len())
+}
+
+func TestUpdaterLastModified(t *testing.T) {
+	lastModified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+	var mu sync.Mutex
+	var ifModifiedSince []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ifModifiedSince = append(ifModifiedSince, r.Header.Get("If-Modified-Since"))
+		mu.Unlock()
+		assert.Empty(t, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		if len(ifModifiedSince) == 1 {
+			w.Write([]byte("10.0.0.0/8"))
+		} else {
+			w.Write([]byte("192.168.0.0/16"))
+		}
+	}))
+	defer ts.Close()
+
+	search := ipsearch.NewIPSearch(cidrs, ipsearch.CIDR)
+	var updates, notModified int
+	u := ipsearch.NewUpdater(search, ts.URL, &ipsearch.UpdaterOptions{
+		OnUpdate:      func(*ipsearch.LoadReport) { updates++ },
+		OnNotModified: func() { notModified++ },
+	})
+	assert.Nil(t, u.Update())
+	assert.NotNil(t, search.Search("10.1.1.1"))
+
+	// the second request is conditional, and the index is not swapped
+	assert.Nil(t, u.Update())
+	assert.Equal(t, []string{"", lastModified}, ifModifiedSince)
+	assert.Equal(t, 1, updates)
+	assert.Equal(t, 1, notModified)
+	assert.NotNil(t, search.Search("10.1.1.1"))
+	assert.Nil(t, search.Search("192.168.1.1"))
+	assert.Nil(t, search.Search("1.0.1.1"))
+}
+
+func TestUpdaterValidate(t *testing.T) {
+	srv := &listServer{}
+	srv.set(strings.Join(cidrs, "\n"))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	search := ipsearch.NewIPSearch(cidrs, ipsearch.CIDR)
+	errNoChina := errors.New("114.114.114.114 is not found")
+	u := ipsearch.NewUpdater(search, ts.URL, &ipsearch.UpdaterOptions{
+		Validate: func(candidate *ipsearch.IPSearch, _ *ipsearch.LoadReport) error {
+			if candidate.Search("114.114.114.114") == nil {
+				return errNoChina
+			}
+			return nil
+		},
+	})
+	assert.True(t, errors.Is(u.Update(), errNoChina))
+	assert.NotNil(t, search.Search("1.0.1.1"))
+
+	srv.set("114.114.0.0/16")
+	assert.Nil(t, u.Update())
+	assert.Nil(t, search.Search("1.0.1.1"))
+
+	u = ipsearch.NewUpdater(search, ts.URL+"/\x7f", nil)
+	assert.NotNil(t, u.Update())
+}
+
+func TestUpdaterStart(t *testing.T) {
+	srv := &listServer{}
+	srv.set("10.0.0.0/8")
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	search := ipsearch.NewIPSearch(cidrs, ipsearch.CIDR)
+	var updates int32
+	// the first poll does not wait for the interval
+	u := ipsearch.NewUpdater(search, ts.URL, &ipsearch.UpdaterOptions{
+		Interval: time.Hour,
+		OnUpdate: func(*ipsearch.LoadReport) { atomic.AddInt32(&updates, 1) },
+	})
+	u.Start()
+	u.Start()
+	for atomic.LoadInt32(&updates) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	u.Stop()
+	u.Stop()
+	assert.NotNil(t, search.Search("10.1.1.1"))
+	assert.Equal(t, int64(1), u.Stats().Checks)
+}